@@ -0,0 +1,6 @@
+{{ template "header.tpl" . }}
+
+  This page is reserved to team members.
+
+  Please connect again with an authorized client certificate.
+{{ template "footer.tpl" . }}
@@ -125,6 +125,10 @@ Templates can use the following functions:
   - build_version: the version of the current instance of `ttserver'
   - build_date: the date of the build of the current instance of *
   `ttserver'
+  - ratelimit_bans: the remote IPs currently banned by the rate limiter
+  - cache_stats: the statistics of the cache stores
+  - cache_keys: the pages stored in the cache, with their expiration
+  - fetch_cache: the results of the fetches kept in the fetch cache
   - bitcoincoreServices: convert to bitcoin services names
   - tablewriter: construct complex tables, see:
 "https://github.com/jedib0t/go-pretty"
@@ -185,4 +185,35 @@ Started: {{ (get $goDataMap "process_start_time_seconds") }}
 {{ ginfo (tablewriter (dict "data" (list (list "Template Processing (in ms)")) "width" $width "text-alignment" "center" "box-draw-separate-rows" false "box-separator" "~" "box-left" ")" "box-right" ")")) }}
 {{ ginfo (tablewriter (dict "data" $procData "header-data" $procHeaderData "width" $width "text-alignment" "left")) }}
 {{ end }}
+{{/********** Cache stores **********/ -}}
+{{ $storesData := list -}}
+{{ range $s := cache_stats -}}
+{{     $storesData = append $storesData (list $s.Store $s.Entries $s.Bytes $s.Hits $s.Misses $s.Evictions $s.Expirations) -}}
+{{ end -}}
+{{ if $storesData -}}
+{{ ginfo (tablewriter (dict "data" (list (list "Cache stores")) "width" $width "text-alignment" "center" "box-draw-separate-rows" false "box-separator" "~" "box-left" ")" "box-right" ")")) }}
+{{ ginfo (tablewriter (dict "data" $storesData "header-data" (list "store" "items" "bytes" "hits" "misses" "evict" "expir") "width" $width "text-alignment" "left")) }}
+{{ end -}}
+{{/********** Cached pages **********/ -}}
+{{ $keysData := list -}}
+{{ range $k := cache_keys -}}
+{{     $ttl := "none" -}}
+{{     if ge $k.TTL 0 -}}
+{{         $ttl = ($k.TTL.Truncate 1000000000).String -}}
+{{     end -}}
+{{     $keysData = append $keysData (list $k.Key (bytesize (float64 $k.Size)) $ttl) -}}
+{{ end -}}
+{{ if $keysData -}}
+{{ ginfo (tablewriter (dict "data" (list (list "Cached pages")) "width" $width "text-alignment" "center" "box-draw-separate-rows" false "box-separator" "~" "box-left" ")" "box-right" ")")) }}
+{{ ginfo (tablewriter (dict "data" $keysData "header-data" (list "key" "size" "expires in") "width" $width "text-alignment" "left")) }}
+{{ end -}}
+{{/********** Fetch cache **********/ -}}
+{{ $fetchData := list -}}
+{{ range $f := fetch_cache -}}
+{{     $fetchData = append $fetchData (list $f.Type $f.URI (bytesize (float64 $f.Size)) ($f.Fetched.Format "15:04:05")) -}}
+{{ end -}}
+{{ if $fetchData -}}
+{{ ginfo (tablewriter (dict "data" (list (list "Fetch cache")) "width" $width "text-alignment" "center" "box-draw-separate-rows" false "box-separator" "~" "box-left" ")" "box-right" ")")) }}
+{{ ginfo (tablewriter (dict "data" $fetchData "header-data" (list "type" "uri" "size" "fetched") "width" $width "text-alignment" "left")) }}
+{{ end -}}
 {{ template "footer.tpl" . }}
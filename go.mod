@@ -11,6 +11,7 @@ require (
 	github.com/go-acme/lego/v3 v3.9.0
 	github.com/go-openapi/strfmt v0.19.7 // indirect
 	github.com/goji/httpauth v0.0.0-20160601135302-2da839ab0f4d
+	github.com/gomodule/redigo v1.8.3
 	github.com/google/go-cmp v0.5.2
 	github.com/google/uuid v1.1.2
 	github.com/hako/durafmt v0.0.0-20200710122514-c0fb7b4da026
@@ -20,8 +21,11 @@ require (
 	github.com/libdns/digitalocean v0.0.0-20200817185712-f11d70f2506c
 	github.com/libdns/gandi v1.0.2
 	github.com/libdns/hetzner v0.0.0-20201023124918-371d6e7b28d6
+	github.com/libdns/libdns v0.1.0
+	github.com/mholt/acmez v0.1.1
+	github.com/miekg/dns v1.1.30
 	github.com/mmcdole/gofeed v1.1.0
-	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/pires/go-proxyproto v0.2.0
 	github.com/prometheus/client_golang v1.8.0
 	github.com/prometheus/client_model v0.2.0
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ttspace "github.com/tristan-weil/ttserver/server/space"
+)
+
+// adminHandler returns the handler of the admin endpoint, served by the Prometheus server.
+func (m *Manager) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/cache/invalidate", m.adminCacheInvalidate)
+	mux.HandleFunc("/cache/flush", m.adminCacheFlush)
+
+	return mux
+}
+
+// adminCacheInvalidate deletes the pages of the routes matching the pattern,
+// and renders them again if asked.
+func (m *Manager) adminCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	space := m.adminSpace(w, r)
+	if space == nil {
+		return
+	}
+
+	pattern := r.FormValue("route")
+	if pattern == "" {
+		http.Error(w, "missing route", http.StatusBadRequest)
+		return
+	}
+
+	var render bool
+
+	if value := r.FormValue("render"); value != "" {
+		var err error
+
+		render, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid render: %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	m.logger.
+		WithField("svc", "admin").
+		Infof("invalidating the routes %s...", pattern)
+
+	deleted, routes, err := space.InvalidateCache(pattern, render)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.logger.
+		WithField("svc", "admin").
+		Debugf("invalidating the routes %s... done!", pattern)
+
+	fmt.Fprintf(w, "deleted: %d\n", deleted)
+
+	if render {
+		fmt.Fprintf(w, "rendered: %s\n", strings.Join(routes, " "))
+	}
+}
+
+// adminCacheFlush flushes the pages, the results of the fetches, or both.
+func (m *Manager) adminCacheFlush(w http.ResponseWriter, r *http.Request) {
+	space := m.adminSpace(w, r)
+	if space == nil {
+		return
+	}
+
+	store := r.FormValue("store")
+
+	switch store {
+	case "", "pages", "fetch":
+	default:
+		http.Error(w, fmt.Sprintf("invalid store: %s", store), http.StatusBadRequest)
+		return
+	}
+
+	m.logger.
+		WithField("svc", "admin").
+		Infof("flushing space's cache...")
+
+	if store != "fetch" {
+		space.GetCache().Flush()
+		space.Warmup()
+
+		fmt.Fprintf(w, "flushed: pages\n")
+	}
+
+	if store != "pages" {
+		space.GetFetchCache().Flush()
+
+		fmt.Fprintf(w, "flushed: fetch\n")
+	}
+
+	m.logger.
+		WithField("svc", "admin").
+		Debugf("flushing space's cache... done!")
+}
+
+// adminSpace checks the request and returns the space, or nil if an error has been sent.
+func (m *Manager) adminSpace(w http.ResponseWriter, r *http.Request) *ttspace.Space {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return nil
+	}
+
+	m.mu.RLock()
+	space := m.space
+	m.mu.RUnlock()
+
+	if space == nil {
+		http.Error(w, "space not available", http.StatusServiceUnavailable)
+		return nil
+	}
+
+	return space
+}
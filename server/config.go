@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
 	"regexp"
+	"strings"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/prometheus/client_golang/prometheus"
+	ttacmedns "github.com/tristan-weil/ttserver/svc/acmedns"
 	ttutils "github.com/tristan-weil/ttserver/utils"
 )
 
@@ -72,6 +76,22 @@ func (m *Manager) readConfig() error {
 		return fmt.Errorf("unable to unmarshall config file, %s", err)
 	}
 
+	//
+	// PROMETHEUS
+	//
+
+	// Admin string `json:"admin,omitempty"`
+	jsonConfig.Prometheus.Admin = strings.TrimRight(jsonConfig.Prometheus.Admin, "/")
+
+	if admin := jsonConfig.Prometheus.Admin; admin != "" && (admin[0] != '/' || admin == jsonConfig.Prometheus.Endpoint) {
+		return fmt.Errorf("invalid prometheus admin path: %s", admin)
+	}
+
+	if auth := jsonConfig.Prometheus.Auth; jsonConfig.Prometheus.Admin != "" &&
+		(auth == nil || auth.AuthBasic == nil || ttutils.IsStringEmpty(auth.AuthBasic.Username) || ttutils.IsStringEmpty(auth.AuthBasic.Password)) {
+		return fmt.Errorf("the prometheus admin endpoint needs the basic authentication")
+	}
+
 	//
 	// SPACE
 	//
@@ -81,6 +101,170 @@ func (m *Manager) readConfig() error {
 		jsonConfig.Space.Listener.Address = ttutils.String(envListenerAddress)
 	}
 
+	// ProxyProtocol *string `json:"proxyprotocol,omitempty"`
+	proxyProtocol := ttutils.StringValue(jsonConfig.Space.Listener.ProxyProtocol)
+	if proxyProtocol != "" && proxyProtocol != "disabled" && proxyProtocol != "enabled" && proxyProtocol != "v1" && proxyProtocol != "v2" {
+		return fmt.Errorf("unknown PROXY protocol mode: %s", proxyProtocol)
+	}
+
+	// ProxyProtocolPolicy *ListenerProxyProtocolPolicyConfig `json:"proxyprotocolpolicy,omitempty"`
+	if proxyProtocol == "enabled" || proxyProtocol == "v1" || proxyProtocol == "v2" {
+		if jsonConfig.Space.Listener.ProxyProtocolPolicy == nil {
+			jsonConfig.Space.Listener.ProxyProtocolPolicy = &ttutils.ListenerProxyProtocolPolicyConfig{}
+		}
+
+		policy := jsonConfig.Space.Listener.ProxyProtocolPolicy
+
+		// the versions not enabled by the mode are rejected by default
+		defaultV1, defaultV2 := "use", "use"
+		if proxyProtocol == "v1" {
+			defaultV2 = "reject"
+		} else if proxyProtocol == "v2" {
+			defaultV1 = "reject"
+		}
+
+		if ttutils.IsStringEmpty(policy.V1) {
+			policy.V1 = ttutils.String(defaultV1)
+		}
+
+		if ttutils.IsStringEmpty(policy.V2) {
+			policy.V2 = ttutils.String(defaultV2)
+		}
+
+		for _, p := range []string{ttutils.StringValue(policy.V1), ttutils.StringValue(policy.V2)} {
+			if p != "require" && p != "use" && p != "ignore" && p != "reject" {
+				return fmt.Errorf("unknown PROXY protocol version policy: %s", p)
+			}
+		}
+
+		// a connection without header can't tell which version it would have used:
+		// a header is required for the whole listener, the other version can't be accepted
+		v1, v2 := ttutils.StringValue(policy.V1), ttutils.StringValue(policy.V2)
+		if (v1 == "require" && v2 != "require" && v2 != "reject") || (v2 == "require" && v1 != "require" && v1 != "reject") {
+			return fmt.Errorf("invalid PROXY protocol policy (v1: %s, v2: %s): with require, the other version must be required or rejected", v1, v2)
+		}
+
+		if ttutils.IsStringEmpty(policy.Untrusted) {
+			policy.Untrusted = ttutils.String("reject")
+		}
+
+		if u := ttutils.StringValue(policy.Untrusted); u != "ignore" && u != "reject" {
+			return fmt.Errorf("unknown PROXY protocol untrusted policy: %s", u)
+		}
+
+		// only the upstreams running on the same host are trusted by default
+		if len(policy.Trusted) == 0 {
+			policy.Trusted = []string{"127.0.0.0/8", "::1"}
+		}
+
+		if policy.TrustedNets, err = ttutils.ParseCIDRs(policy.Trusted); err != nil {
+			return fmt.Errorf("invalid PROXY protocol trusted upstreams: %s", err)
+		}
+	} else if jsonConfig.Space.Listener.ProxyProtocolPolicy != nil {
+		return fmt.Errorf("a PROXY protocol policy needs the PROXY protocol to be enabled")
+	}
+
+	// Timeouts *ListenerTimeoutsConfig `json:"timeouts,omitempty"`
+	if jsonConfig.Space.Listener.Timeouts == nil {
+		jsonConfig.Space.Listener.Timeouts = &ttutils.ListenerTimeoutsConfig{}
+	}
+
+	for _, timeout := range []**int{
+		&jsonConfig.Space.Listener.Timeouts.Handshake,
+		&jsonConfig.Space.Listener.Timeouts.Read,
+		&jsonConfig.Space.Listener.Timeouts.Process,
+		&jsonConfig.Space.Listener.Timeouts.Write,
+		&jsonConfig.Space.Listener.Timeouts.Drain,
+	} {
+		if *timeout == nil {
+			*timeout = ttutils.Int(60)
+		}
+
+		if ttutils.IntValue(*timeout) < 0 {
+			return fmt.Errorf("a listener timeout can't be negative")
+		}
+	}
+
+	// MaxQueryBytes *int `json:"maxquerybytes,omitempty"`
+	if ttutils.IntValue(jsonConfig.Space.Listener.MaxQueryBytes) < 0 {
+		return fmt.Errorf("the maximum size of the queries can't be negative")
+	}
+
+	// Limits *ListenerLimitsConfig `json:"limits,omitempty"`
+	if jsonConfig.Space.Listener.Limits == nil {
+		jsonConfig.Space.Listener.Limits = &ttutils.ListenerLimitsConfig{}
+	}
+
+	if ttutils.IntValue(jsonConfig.Space.Listener.Limits.MaxConnections) < 0 ||
+		ttutils.IntValue(jsonConfig.Space.Listener.Limits.MaxConnectionsPerIP) < 0 {
+		return fmt.Errorf("a listener connections limit can't be negative")
+	}
+
+	if ttutils.IsStringEmpty(jsonConfig.Space.Listener.Limits.OnLimit) {
+		jsonConfig.Space.Listener.Limits.OnLimit = ttutils.String("busy")
+	}
+
+	if onLimit := ttutils.StringValue(jsonConfig.Space.Listener.Limits.OnLimit); onLimit != "busy" && onLimit != "queue" {
+		return fmt.Errorf("unknown listener onlimit action: %s", onLimit)
+	}
+
+	if jsonConfig.Space.Listener.Limits.QueueTimeout == nil {
+		jsonConfig.Space.Listener.Limits.QueueTimeout = ttutils.Int(5)
+	}
+
+	if ttutils.IntValue(jsonConfig.Space.Listener.Limits.QueueTimeout) < 0 {
+		return fmt.Errorf("the listener queue timeout can't be negative")
+	}
+
+	// ACL *ACLConfig `json:"acl,omitempty"`
+	if jsonConfig.Space.Listener.ACL != nil {
+		if err := jsonConfig.Space.Listener.ACL.Compile(); err != nil {
+			return fmt.Errorf("invalid listener acl: %s", err)
+		}
+
+		if jsonConfig.Space.Listener.ACL.UsesGeoIP() && jsonConfig.Space.GeoIP == nil {
+			return fmt.Errorf("invalid listener acl: the countries and the ASNs need a GeoIP database")
+		}
+	}
+
+	// Tarpit *ListenerTarpitConfig `json:"tarpit,omitempty"`
+	if jsonConfig.Space.Listener.Tarpit != nil {
+		tarpit := jsonConfig.Space.Listener.Tarpit
+
+		for _, match := range tarpit.Match {
+			if !ttutils.StringSliceContains([]string{"http", "tls", "binary"}, match) {
+				return fmt.Errorf("invalid tarpit match %s (allowed: http, tls, binary)", match)
+			}
+		}
+
+		tarpit.PatternsRegexp = nil
+		for _, pattern := range tarpit.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid tarpit pattern %s: %s", pattern, err)
+			}
+
+			tarpit.PatternsRegexp = append(tarpit.PatternsRegexp, re)
+		}
+
+		for _, v := range []struct {
+			value        **int
+			defaultValue int
+		}{
+			{&tarpit.MaxConnections, 32},
+			{&tarpit.Duration, 300},
+			{&tarpit.Interval, 10},
+		} {
+			if *v.value == nil {
+				*v.value = ttutils.Int(v.defaultValue)
+			}
+
+			if ttutils.IntValue(*v.value) < 1 {
+				return fmt.Errorf("the tarpit options must be greater than 0")
+			}
+		}
+	}
+
 	// TLS           *TLSConfig `json:"tls,omitempty"`
 	if jsonConfig.Space.Listener.TLSConfig != nil {
 		if jsonConfig.Space.Listener.TLSConfig.ACME != nil {
@@ -109,9 +293,46 @@ func (m *Manager) readConfig() error {
 				jsonConfig.Space.Listener.TLSConfig.ACME.Storage.File.Path = ttutils.String(".certmagic")
 			}
 
-			// DNSProvider *string                      `json:"dnsprovider,omitempty"`
-			if ttutils.IsStringEmpty(jsonConfig.Space.Listener.TLSConfig.ACME.DNSProvider) {
-				return fmt.Errorf("LetsEncrypt has no dns-provider configured")
+			// Challenges []string `json:"challenges,omitempty"`
+			if ttutils.IsStringSliceEmpty(jsonConfig.Space.Listener.TLSConfig.ACME.Challenges) {
+				jsonConfig.Space.Listener.TLSConfig.ACME.Challenges = []string{"dns-01"}
+			}
+
+			for _, challenge := range jsonConfig.Space.Listener.TLSConfig.ACME.Challenges {
+				switch challenge {
+				case "dns-01":
+					// DNSProvider *string                      `json:"dnsprovider,omitempty"`
+					if ttutils.IsStringEmpty(jsonConfig.Space.Listener.TLSConfig.ACME.DNSProvider) {
+						return fmt.Errorf("LetsEncrypt has no dns-provider configured")
+					}
+
+					// DNSParameters map[string]string `json:"dnsparameters,omitempty"`
+					if _, err := ttacmedns.New(
+						ttutils.StringValue(jsonConfig.Space.Listener.TLSConfig.ACME.DNSProvider),
+						jsonConfig.Space.Listener.TLSConfig.ACME.DNSParameters,
+					); err != nil {
+						return err
+					}
+				case "http-01":
+					// HTTP *TLSACMEHTTPConfig `json:"http,omitempty"`
+					if jsonConfig.Space.Listener.TLSConfig.ACME.HTTP == nil {
+						jsonConfig.Space.Listener.TLSConfig.ACME.HTTP = &ttutils.TLSACMEHTTPConfig{}
+					}
+
+					if ttutils.IsStringEmpty(jsonConfig.Space.Listener.TLSConfig.ACME.HTTP.Address) {
+						jsonConfig.Space.Listener.TLSConfig.ACME.HTTP.Address = ttutils.String(":80")
+					}
+				case "tls-alpn-01":
+				default:
+					return fmt.Errorf("unknown ACME challenge: %s", challenge)
+				}
+			}
+
+			// TrustedRoots *string `json:"trustedroots,omitempty"`
+			if ttutils.NotStringEmpty(jsonConfig.Space.Listener.TLSConfig.ACME.TrustedRoots) &&
+				!ttutils.CheckFileExists(ttutils.StringValue(jsonConfig.Space.Listener.TLSConfig.ACME.TrustedRoots)) {
+
+				return fmt.Errorf("unable to find ACME trusted roots: %s", ttutils.StringValue(jsonConfig.Space.Listener.TLSConfig.ACME.TrustedRoots))
 			}
 
 			// Email       *string                      `json:"email,omitempty"`
@@ -119,6 +340,50 @@ func (m *Manager) readConfig() error {
 				return fmt.Errorf("LetsEncrypt has no email configured")
 			}
 		}
+
+		// SelfSigned *TLSSelfSignedConfig `json:"selfsigned,omitempty"`
+		if jsonConfig.Space.Listener.TLSConfig.SelfSigned != nil {
+			if jsonConfig.Space.Listener.TLSConfig.ACME != nil {
+				return fmt.Errorf("only one TLS certificates manager can be configured: acme or selfsigned")
+			}
+
+			if len(jsonConfig.Space.Listener.Domains) == 0 {
+				return fmt.Errorf("a self-signed certificate needs at least one domain")
+			}
+
+			// Path *string `json:"path,omitempty"`
+			if ttutils.IsStringEmpty(jsonConfig.Space.Listener.TLSConfig.SelfSigned.Path) {
+				jsonConfig.Space.Listener.TLSConfig.SelfSigned.Path = ttutils.String(".selfsigned")
+			}
+
+			// Validity *int `json:"validity,omitempty"`
+			if ttutils.IntValue(jsonConfig.Space.Listener.TLSConfig.SelfSigned.Validity) <= 0 {
+				jsonConfig.Space.Listener.TLSConfig.SelfSigned.Validity = ttutils.Int(365)
+			}
+		}
+
+		// ClientAuth *TLSClientAuthConfig `json:"clientauth,omitempty"`
+		if jsonConfig.Space.Listener.TLSConfig.ClientAuth != nil {
+			clientAuth := jsonConfig.Space.Listener.TLSConfig.ClientAuth
+
+			// Mode *string `json:"mode,omitempty"`
+			if ttutils.IsStringEmpty(clientAuth.Mode) {
+				clientAuth.Mode = ttutils.String("optional")
+			}
+
+			if m := ttutils.StringValue(clientAuth.Mode); m != "optional" && m != "required" {
+				return fmt.Errorf("unknown client authentication mode: %s", m)
+			}
+
+			// CA *string `json:"ca,omitempty"`
+			if ttutils.NotStringEmpty(clientAuth.CA) {
+				if !ttutils.CheckFileExists(ttutils.StringValue(clientAuth.CA)) {
+					return fmt.Errorf("unable to find client authentication CA bundle: %s", ttutils.StringValue(clientAuth.CA))
+				}
+			} else if !ttutils.BoolValue(clientAuth.AcceptSelfSigned) {
+				return fmt.Errorf("client authentication has no CA bundle configured and does not accept self-signed certificates")
+			}
+		}
 	}
 
 	// Handler *HandlerConfig `json:"handler,omitempty"`
@@ -126,6 +391,239 @@ func (m *Manager) readConfig() error {
 		return fmt.Errorf("no handler configured")
 	}
 
+	// RateLimit *RateLimitConfig `json:"ratelimit,omitempty"`
+	if jsonConfig.Space.RateLimit != nil {
+		rateLimit := jsonConfig.Space.RateLimit
+
+		if ttutils.Float64Value(rateLimit.Rate) < 0 || (rateLimit.Burst != nil && ttutils.IntValue(rateLimit.Burst) < 1) {
+			return fmt.Errorf("invalid rate limit for the space")
+		}
+
+		if rateLimit.Burst == nil {
+			rateLimit.Burst = ttutils.Int(int(math.Max(1, math.Ceil(ttutils.Float64Value(rateLimit.Rate)))))
+		}
+
+		if rateLimit.Ban != nil {
+			for _, v := range []struct {
+				value        **int
+				defaultValue int
+			}{
+				{&rateLimit.Ban.Threshold, 10},
+				{&rateLimit.Ban.Duration, 60},
+				{&rateLimit.Ban.Factor, 2},
+				{&rateLimit.Ban.Max, 3600},
+			} {
+				if *v.value == nil {
+					*v.value = ttutils.Int(v.defaultValue)
+				}
+
+				if ttutils.IntValue(*v.value) < 1 {
+					return fmt.Errorf("the rate limit ban options must be greater than 0")
+				}
+			}
+		}
+	}
+
+	// Cache *CacheConfig `json:"cache,omitempty"`
+	// MaxRoutes *int `json:"maxroutes,omitempty"`
+	if jsonConfig.Space.Cache.MaxRoutes == nil {
+		jsonConfig.Space.Cache.MaxRoutes = ttutils.Int(1024)
+	}
+
+	if ttutils.IntValue(jsonConfig.Space.Cache.MaxRoutes) < 0 {
+		return fmt.Errorf("the maximum number of cached routes can't be negative")
+	}
+
+	jsonConfig.Space.RoutesCache = ttutils.NewLRU(ttutils.IntValue(jsonConfig.Space.Cache.MaxRoutes), 0)
+
+	// Key []string `json:"key,omitempty"`
+	if jsonConfig.Space.Cache.Key == nil {
+		jsonConfig.Space.Cache.Key = defaultCacheKey(&jsonConfig)
+	}
+
+	if err := checkCacheKey(jsonConfig.Space.Cache.Key); err != nil {
+		return fmt.Errorf("invalid cache key: %s", err)
+	}
+
+	// Memory *CacheMemoryConfig `json:"memory,omitempty"`
+	if jsonConfig.Space.Cache.Memory != nil {
+		// MaxEntries *int `json:"maxentries,omitempty"`
+		// MaxBytes   *int `json:"maxbytes,omitempty"`
+		if jsonConfig.Space.Cache.Memory.MaxBytes == nil {
+			jsonConfig.Space.Cache.Memory.MaxBytes = ttutils.Int(64 * 1024 * 1024)
+		}
+
+		if ttutils.IntValue(jsonConfig.Space.Cache.Memory.MaxEntries) < 0 ||
+			ttutils.IntValue(jsonConfig.Space.Cache.Memory.MaxBytes) < 0 {
+			return fmt.Errorf("a memory cache limit can't be negative")
+		}
+	}
+
+	// Disk *CacheDiskConfig `json:"disk,omitempty"`
+	if jsonConfig.Space.Cache.Disk != nil {
+		// the disk store replaces the default in-memory one
+		jsonConfig.Space.Cache.Memory = nil
+
+		// Path *string `json:"path,omitempty"`
+		if ttutils.IsStringEmpty(jsonConfig.Space.Cache.Disk.Path) {
+			jsonConfig.Space.Cache.Disk.Path = ttutils.String(".cache")
+		}
+
+		jsonConfig.Space.Cache.Disk.Path = ttutils.FilePathClean(jsonConfig.Space.Cache.Disk.Path)
+
+		// Cleanup *int `json:"cleanup,omitempty"`
+		if jsonConfig.Space.Cache.Disk.Cleanup == nil {
+			jsonConfig.Space.Cache.Disk.Cleanup = ttutils.Int(600)
+		}
+
+		if ttutils.IntValue(jsonConfig.Space.Cache.Disk.Cleanup) < 0 {
+			return fmt.Errorf("the disk cache cleanup delay can't be negative")
+		}
+	}
+
+	// Redis *CacheRedisConfig `json:"redis,omitempty"`
+	if jsonConfig.Space.Cache.Redis != nil {
+		if jsonConfig.Space.Cache.Disk != nil {
+			return fmt.Errorf("only one cache store can be configured: disk or redis")
+		}
+
+		// the redis store replaces the default in-memory one
+		jsonConfig.Space.Cache.Memory = nil
+
+		redisConfig := jsonConfig.Space.Cache.Redis
+
+		// Address *string `json:"address,omitempty"`
+		if ttutils.IsStringEmpty(redisConfig.Address) {
+			redisConfig.Address = ttutils.String("127.0.0.1:6379")
+		}
+
+		if _, _, err := net.SplitHostPort(ttutils.StringValue(redisConfig.Address)); err != nil {
+			return fmt.Errorf("invalid redis address: %s", err)
+		}
+
+		// DB *int `json:"db,omitempty"`
+		if ttutils.IntValue(redisConfig.DB) < 0 {
+			return fmt.Errorf("the redis database can't be negative")
+		}
+
+		// Prefix *string `json:"prefix,omitempty"`
+		if redisConfig.Prefix == nil {
+			redisConfig.Prefix = ttutils.String("ttserver:")
+		}
+
+		// Timeout *int `json:"timeout,omitempty"`
+		// Retry   *int `json:"retry,omitempty"`
+		for _, v := range []struct {
+			value        **int
+			defaultValue int
+		}{
+			{&redisConfig.Timeout, 1},
+			{&redisConfig.Retry, 10},
+		} {
+			if *v.value == nil {
+				*v.value = ttutils.Int(v.defaultValue)
+			}
+
+			if ttutils.IntValue(*v.value) < 1 {
+				return fmt.Errorf("the redis timeout and retry delays must be greater than 0")
+			}
+		}
+
+		// TLS *CacheRedisTLSConfig `json:"tls,omitempty"`
+		if redisConfig.TLS != nil {
+			if ttutils.NotStringEmpty(redisConfig.TLS.CA) && !ttutils.CheckFileExists(ttutils.StringValue(redisConfig.TLS.CA)) {
+				return fmt.Errorf("unable to find redis CA bundle: %s", ttutils.StringValue(redisConfig.TLS.CA))
+			}
+
+			if ttutils.IsStringEmpty(redisConfig.TLS.Cert) != ttutils.IsStringEmpty(redisConfig.TLS.Key) {
+				return fmt.Errorf("a redis client certificate needs both cert and key")
+			}
+
+			for _, file := range []*string{redisConfig.TLS.Cert, redisConfig.TLS.Key} {
+				if ttutils.NotStringEmpty(file) && !ttutils.CheckFileExists(ttutils.StringValue(file)) {
+					return fmt.Errorf("unable to find redis client certificate file: %s", ttutils.StringValue(file))
+				}
+			}
+		}
+	}
+
+	// Fetch *CacheFetchConfig `json:"fetch,omitempty"`
+	if jsonConfig.Space.Cache.Fetch == nil {
+		jsonConfig.Space.Cache.Fetch = &ttutils.CacheFetchConfig{}
+	}
+
+	fetchCacheConfig := jsonConfig.Space.Cache.Fetch
+
+	// Expiration *int `json:"expiration,omitempty"`
+	if fetchCacheConfig.Expiration == nil {
+		fetchCacheConfig.Expiration = ttutils.Int(60)
+	}
+
+	if ttutils.IntValue(fetchCacheConfig.Expiration) < -1 {
+		return fmt.Errorf("invalid fetch cache expiration: %d", ttutils.IntValue(fetchCacheConfig.Expiration))
+	}
+
+	// Cleanup    *int `json:"cleanup,omitempty"`
+	// MaxEntries *int `json:"maxentries,omitempty"`
+	// MaxBytes   *int `json:"maxbytes,omitempty"`
+	for _, v := range []struct {
+		value        **int
+		defaultValue int
+	}{
+		{&fetchCacheConfig.Cleanup, 60},
+		{&fetchCacheConfig.MaxEntries, 1024},
+		{&fetchCacheConfig.MaxBytes, 16 * 1024 * 1024},
+	} {
+		if *v.value == nil {
+			*v.value = ttutils.Int(v.defaultValue)
+		}
+
+		if ttutils.IntValue(*v.value) < 0 {
+			return fmt.Errorf("the fetch cache options can't be negative")
+		}
+	}
+
+	// Warmup *CacheWarmupConfig `json:"warmup,omitempty"`
+	if jsonConfig.Space.Cache.Warmup != nil {
+		// Concurrency *int `json:"concurrency,omitempty"`
+		if jsonConfig.Space.Cache.Warmup.Concurrency == nil {
+			jsonConfig.Space.Cache.Warmup.Concurrency = ttutils.Int(2)
+		}
+
+		if ttutils.IntValue(jsonConfig.Space.Cache.Warmup.Concurrency) < 1 {
+			return fmt.Errorf("the warm-up concurrency must be greater than 0")
+		}
+
+		// Routes []string `json:"routes,omitempty"`
+		for _, route := range jsonConfig.Space.Cache.Warmup.Routes {
+			if route == "" || route[0] == '~' {
+				return fmt.Errorf("invalid warm-up route: '%s'", route)
+			}
+		}
+	}
+
+	// Stats *int `json:"stats,omitempty"`
+	if jsonConfig.Space.Cache.Stats == nil {
+		jsonConfig.Space.Cache.Stats = ttutils.Int(60)
+	}
+
+	if ttutils.IntValue(jsonConfig.Space.Cache.Stats) < 0 {
+		return fmt.Errorf("the cache stats delay can't be negative")
+	}
+
+	// GeoIP *GeoIPConfig `json:"geoip,omitempty"`
+	if jsonConfig.Space.GeoIP != nil {
+		if len(jsonConfig.Space.GeoIP.Databases) == 0 {
+			return fmt.Errorf("no GeoIP database configured")
+		}
+
+		for _, database := range jsonConfig.Space.GeoIP.Databases {
+			if database == "" {
+				return fmt.Errorf("invalid GeoIP database: empty path")
+			}
+		}
+	}
+
 	// BaseDir  *string `json:"basedir,omitempty"`
 	jsonConfig.Space.BaseDir = ttutils.FilePathClean(jsonConfig.Space.BaseDir)
 
@@ -157,6 +655,8 @@ func (m *Manager) readConfig() error {
 
 	// Routes map[string]*RouteConfig `json:"routes,omitempty"`
 	for routeName, routeConf := range jsonConfig.Space.Routes {
+		routeConf.Name = routeName
+
 		// File              *string `json:"file,omitempty"`
 		// Template              *string `json:"template,omitempty"`
 		if ttutils.NotStringEmpty(routeConf.Template) {
@@ -191,6 +691,44 @@ func (m *Manager) readConfig() error {
 				if ttutils.IsStringEmpty(fetchConf.Type) || ttutils.IsStringEmpty(fetchConf.URI) {
 					return fmt.Errorf("unable to find valid configuration for fetch %s: %s", fetchName, err)
 				}
+
+				// Expiration *int `json:"expiration,omitempty"`
+				if fetchConf.Expiration == nil {
+					fetchConf.Expiration = fetchCacheConfig.Expiration
+				}
+
+				if ttutils.IntValue(fetchConf.Expiration) < -1 {
+					return fmt.Errorf("invalid expiration for fetch %s: %d", fetchName, ttutils.IntValue(fetchConf.Expiration))
+				}
+			}
+		}
+
+		// ClientCert *RouteClientCertConfig `json:"clientcert,omitempty"`
+		if routeConf.ClientCert != nil {
+			for i, fingerprint := range routeConf.ClientCert.Fingerprints {
+				routeConf.ClientCert.Fingerprints[i] = ttutils.NormalizeFingerprint(fingerprint)
+			}
+		}
+
+		// RateLimit *RouteRateLimitConfig `json:"ratelimit,omitempty"`
+		if routeConf.RateLimit != nil {
+			if ttutils.Float64Value(routeConf.RateLimit.Rate) <= 0 || (routeConf.RateLimit.Burst != nil && ttutils.IntValue(routeConf.RateLimit.Burst) < 1) {
+				return fmt.Errorf("invalid rate limit for route %s", routeName)
+			}
+
+			if routeConf.RateLimit.Burst == nil {
+				routeConf.RateLimit.Burst = ttutils.Int(int(math.Max(1, math.Ceil(ttutils.Float64Value(routeConf.RateLimit.Rate)))))
+			}
+		}
+
+		// ACL *ACLConfig `json:"acl,omitempty"`
+		if routeConf.ACL != nil {
+			if err := routeConf.ACL.Compile(); err != nil {
+				return fmt.Errorf("invalid acl for route %s: %s", routeName, err)
+			}
+
+			if routeConf.ACL.UsesGeoIP() && jsonConfig.Space.GeoIP == nil {
+				return fmt.Errorf("invalid acl for route %s: the countries and the ASNs need a GeoIP database", routeName)
 			}
 		}
 
@@ -201,13 +739,31 @@ func (m *Manager) readConfig() error {
 			}
 		}
 
+		if ttutils.IntValue(routeConf.Cache.StaleWhileRevalidate) < 0 || ttutils.IntValue(routeConf.Cache.StaleIfError) < 0 {
+			return fmt.Errorf("the stale windows of the cache of route %s can't be negative", routeName)
+		}
+
 		// Cron  *RouteCronConfig             `json:"cron,omitempty"`
 		if routeConf.Cron != nil {
 			routeConf.Cache = &ttutils.RouteCacheConfig{
 				Expiration: ttutils.Int(0),
+				Key:        routeConf.Cache.Key,
 			}
 		}
 
+		if routeConf.Cache.Key == nil {
+			routeConf.Cache.Key = jsonConfig.Space.Cache.Key
+		}
+
+		// a page rendered for a client certificate is never served to the other clients
+		if routeConf.ClientCert != nil && !ttutils.StringSliceContains(routeConf.Cache.Key, "clientcert") {
+			routeConf.Cache.Key = append(append([]string{}, routeConf.Cache.Key...), "clientcert")
+		}
+
+		if err := checkCacheKey(routeConf.Cache.Key); err != nil {
+			return fmt.Errorf("invalid cache key for route %s: %s", routeName, err)
+		}
+
 		// populating RoutesRegexp map[string]*RouteRegexpConfig
 		if routeName[0] == '~' {
 			if jsonConfig.Space.RoutesRegexp == nil {
@@ -228,7 +784,7 @@ func (m *Manager) readConfig() error {
 	}
 
 	// add err routes
-	for _, s := range []string{"404", "500"} {
+	for _, s := range []string{"403", "404", "500"} {
 		notFound := true
 
 		for routeName := range jsonConfig.Space.Routes {
@@ -242,6 +798,7 @@ func (m *Manager) readConfig() error {
 			routeCode := &ttutils.RouteConfig{}
 			routeCode.Cache = &ttutils.RouteCacheConfig{
 				Expiration: ttutils.Int(0),
+				Key:        jsonConfig.Space.Cache.Key,
 			}
 
 			if tpl, err := securejoin.SecureJoin(ttutils.StringValue(jsonConfig.Space.BaseDir), s+".tpl"); err == nil {
@@ -260,3 +817,46 @@ func (m *Manager) readConfig() error {
 
 	return nil
 }
+
+// defaultCacheKey returns the default variants of the pages. The domain and the port are only
+// variants when they can change between the clients, and the client certificate, the GeoIP and the PROXY
+// protocol TLVs are left to the routes using them: otherwise the pages rendered by the cron, the warm-up
+// and the revalidations, which don't have a client, wouldn't be the ones served to the clients.
+func defaultCacheKey(jsonConfig *ttutils.ConfigRoot) []string {
+	listener := jsonConfig.Space.Listener
+	parameters := jsonConfig.Space.Handler.Parameters
+
+	// with the PROXY protocol, the local address is the one the client reached on the proxy
+	proxyProtocol := ttutils.StringValue(listener.ProxyProtocol)
+	proxied := proxyProtocol == "enabled" || proxyProtocol == "v1" || proxyProtocol == "v2"
+
+	var key []string
+
+	if _, ok := parameters["response_domain"]; !ok {
+		host, _, _ := net.SplitHostPort(ttutils.StringValue(listener.Address))
+		wildcard := host == "" || net.ParseIP(host).IsUnspecified()
+
+		if len(listener.Domains) > 1 || (len(listener.Domains) == 0 && (proxied || wildcard)) {
+			key = append(key, "domain")
+		}
+	}
+
+	if _, ok := parameters["response_port"]; !ok && proxied {
+		key = append(key, "port")
+	}
+
+	return append(key, "query", "handler")
+}
+
+// checkCacheKey checks the variants of a cache key, the route is always a part of the key.
+func checkCacheKey(key []string) error {
+	for _, part := range key {
+		switch part {
+		case "route", "domain", "port", "query", "handler", "clientcert", "geoip", "proxy":
+		default:
+			return fmt.Errorf("unknown part: %s", part)
+		}
+	}
+
+	return nil
+}
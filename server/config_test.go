@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	ttgopher "github.com/tristan-weil/ttserver/server/handler/gopher"
+	ttcache "github.com/tristan-weil/ttserver/svc/cache"
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttwarmup "github.com/tristan-weil/ttserver/svc/warmup"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+func TestDefaultCacheKey(t *testing.T) {
+	variants := []string{"query", "handler"}
+
+	tests := []struct {
+		name       string
+		address    string
+		domains    []string
+		proxy      string
+		parameters map[string]string
+		wanted     []string
+	}{
+		{"one domain", "192.0.2.1:70", []string{"example.org"}, "", nil, variants},
+		{"several domains", "192.0.2.1:70", []string{"example.org", "example.com"}, "", nil, append([]string{"domain"}, variants...)},
+		{"no domain, one address", "192.0.2.1:70", nil, "", nil, variants},
+		{"no domain, wildcard address", "0.0.0.0:70", nil, "", nil, append([]string{"domain"}, variants...)},
+		{"no domain, no host", ":70", nil, "", nil, append([]string{"domain"}, variants...)},
+		{"no domain, proxy", "192.0.2.1:70", nil, "v2", nil, append([]string{"domain", "port"}, variants...)},
+		{"one domain, proxy", "192.0.2.1:70", []string{"example.org"}, "enabled", nil, append([]string{"port"}, variants...)},
+		{"proxy disabled", "192.0.2.1:70", []string{"example.org"}, "disabled", nil, variants},
+		{
+			"response domain and port", "0.0.0.0:70", nil, "v1",
+			map[string]string{"response_domain": "example.org", "response_port": "70"},
+			variants,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonConfig := &ttutils.ConfigRoot{
+				Space: &ttutils.SpaceConfig{
+					Handler: &ttutils.HandlerConfig{Parameters: tt.parameters},
+					Listener: &ttutils.ListenerConfig{
+						Address:       ttutils.String(tt.address),
+						Domains:       tt.domains,
+						ProxyProtocol: ttutils.String(tt.proxy),
+					},
+				},
+			}
+
+			if diff := cmp.Diff(tt.wanted, defaultCacheKey(jsonConfig)); diff != "" {
+				t.Errorf("unexpected key (-wanted +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckCacheKey(t *testing.T) {
+	if err := checkCacheKey([]string{"route", "domain", "port", "query", "handler", "clientcert", "geoip", "proxy"}); err != nil {
+		t.Errorf("a valid key is rejected: %s", err)
+	}
+
+	if err := checkCacheKey([]string{"query", "cookie"}); err == nil {
+		t.Errorf("an unknown part is accepted")
+	}
+}
+
+func TestProxyProtocolPolicy(t *testing.T) {
+	tests := []struct {
+		mode   string
+		v1     string
+		v2     string
+		wanted bool
+	}{
+		{"enabled", "require", "require", true},
+		{"enabled", "require", "reject", true},
+		{"enabled", "reject", "require", true},
+		{"enabled", "require", "use", false},
+		{"enabled", "ignore", "require", false},
+		{"enabled", "", "require", false},
+		{"v2", "", "require", true},
+		{"v1", "require", "", true},
+		{"enabled", "use", "ignore", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s v1 %s v2 %s", tt.mode, tt.v1, tt.v2), func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := `{
+				"space": {
+					"listener": {
+						"proxyprotocol": "` + tt.mode + `",
+						"proxyprotocolpolicy": {"v1": "` + tt.v1 + `", "v2": "` + tt.v2 + `"}
+					},
+					"handler": {"name": "gopher"},
+					"basedir": "` + dir + `"
+				}
+			}`
+
+			_, err := readTestConfig(t, dir, map[string]string{"index.tpl": "index", "ttserver.config": config})
+			if (err == nil) != tt.wanted || (err != nil && !strings.Contains(err.Error(), "invalid PROXY protocol policy")) {
+				t.Errorf("readConfig() error = %v, wanted valid: %t", err, tt.wanted)
+			}
+		})
+	}
+}
+
+// readTestConfig writes the files in the directory and reads its ttserver.config.
+func readTestConfig(t *testing.T, dir string, files map[string]string) (*Manager, error) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("unable to write %s: %s", name, err)
+		}
+	}
+
+	m := &Manager{configFile: filepath.Join(dir, "ttserver.config"), logger: logrus.New()}
+
+	return m, m.readConfig()
+}
+
+// newTestSpace loads a configuration serving the cron route "news" from a temporary directory,
+// with a GeoIP database configured, and returns it with a memory cache.
+func newTestSpace(t *testing.T) (*ttutils.ConfigRoot, ttcache.ICacheCache) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"index.tpl": "index",
+		"news.tpl":  "news",
+		"ttserver.config": `{
+			"space": {
+				"listener": {"address": "127.0.0.1:7070", "domains": ["localhost"]},
+				"handler": {"name": "gopher"},
+				"geoip": {"databases": ["GeoLite2-Country.mmdb"]},
+				"basedir": "` + dir + `",
+				"routes": {"news": {"cron": "0 15 18 * * *"}}
+			}
+		}`,
+	}
+
+	m, err := readTestConfig(t, dir, files)
+	if err != nil {
+		t.Fatalf("unable to read the configuration: %s", err)
+	}
+
+	cache := ttcache.NewCacheMemory(&ttcache.MemoryConfigInput{
+		Expiration:     ttutils.IntValue(m.config.Space.Cache.Expiration),
+		PrometheusFire: testPrometheusFire,
+		Logger:         testLogger(),
+	})
+
+	cache.Start()
+	t.Cleanup(cache.Shutdown)
+
+	return m.config, cache
+}
+
+func testPrometheusFire(*ttprom.PrometheusMetric) error {
+	return nil
+}
+
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return logrus.NewEntry(logger)
+}
+
+// serveTestClient renders the route for a client with the GeoIP, the PROXY protocol TLVs
+// and a client certificate, and returns the status of the cache.
+func serveTestClient(t *testing.T, config *ttutils.ConfigRoot, cache ttcache.ICacheCache, route string) string {
+	conn := &ttconn.Connection{
+		Logger:         testLogger(),
+		PrometheusFire: testPrometheusFire,
+		Config:         config,
+		Cache:          func() ttcache.ICacheCache { return cache },
+
+		Start:         time.Now(),
+		LocalAddress:  "127.0.0.1:7070",
+		RemoteAddress: "192.0.2.1:1234",
+		RemoteIP:      "192.0.2.1",
+		Domain:        "localhost",
+		Port:          "7070",
+
+		GeoIP:      &ttutils.GeoIPInfo{Country: "FR", ASN: 12322},
+		Proxy:      &ttconn.ProxyInfo{SNI: "localhost", UniqueID: "1234", SSL: true},
+		ClientCert: &ttconn.ClientCertificate{Fingerprint: "abcd"},
+	}
+
+	if _, err := new(ttgopher.Handler).Process(conn, route, nil, false); err != nil {
+		t.Fatalf("unable to render %s: %s", route, err)
+	}
+
+	return fmt.Sprint(conn.Logger.Data["cache"])
+}
+
+func TestDefaultCacheKeyCron(t *testing.T) {
+	config, cache := newTestSpace(t)
+
+	cronConn := &ttconn.Connection{
+		Logger:         testLogger(),
+		PrometheusFire: testPrometheusFire,
+		Config:         config,
+		Cache:          func() ttcache.ICacheCache { return cache },
+
+		Start:         time.Now(),
+		LocalAddress:  "127.0.0.1:7070",
+		RemoteAddress: "127.0.0.1:7070",
+		State:         ttconn.CONNECTION_STATUS_CRON,
+	}
+
+	if err := new(ttgopher.Handler).ServeCrontab(cronConn, "news", nil); err != nil {
+		t.Fatalf("unable to render news: %s", err)
+	}
+
+	if status := serveTestClient(t, config, cache, "news"); status != "HIT" {
+		t.Errorf("the page rendered by the cron isn't served to the client (cache: %s)", status)
+	}
+}
+
+func TestDefaultCacheKeyWarmup(t *testing.T) {
+	config, cache := newTestSpace(t)
+
+	w := ttwarmup.NewWarmup(&ttwarmup.ConfigInput{
+		ServeConnHandler: new(ttgopher.Handler),
+		Config:           config,
+		Cache:            func() ttcache.ICacheCache { return cache },
+		PrometheusFire:   testPrometheusFire,
+		Logger:           testLogger(),
+	})
+
+	if rendered := w.Render(context.Background(), []string{"index", "news"}, 1); rendered != 2 {
+		t.Fatalf("%d routes rendered, wanted 2", rendered)
+	}
+
+	for _, route := range []string{"index", "news"} {
+		if status := serveTestClient(t, config, cache, route); status != "HIT" {
+			t.Errorf("the page of %s rendered by the warm-up isn't served to the client (cache: %s)", route, status)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package connection
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"sync"
 	"time"
@@ -12,6 +12,7 @@ import (
 	"github.com/sirupsen/logrus"
 	ttcache "github.com/tristan-weil/ttserver/svc/cache"
 	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttratelimit "github.com/tristan-weil/ttserver/svc/ratelimit"
 	ttutils "github.com/tristan-weil/ttserver/utils"
 )
 
@@ -23,11 +24,17 @@ type (
 		Reader      *bufio.Reader
 		Writer      *bufio.Writer
 
-		Start time.Time
+		Timeouts      Timeouts
+		MaxQueryBytes int64
+
+		Start           time.Time
+		ProcessDeadline time.Time
 
 		Config         *ttutils.ConfigRoot
 		PrometheusFire func(*ttprom.PrometheusMetric) error
 		Cache          func() ttcache.ICacheCache
+		FetchCache     func() *ttcache.Fetch
+		RateLimiter    func() *ttratelimit.RateLimiter
 		Logger         *logrus.Entry
 
 		UUID string
@@ -38,6 +45,11 @@ type (
 
 		LocalAddress  string
 		RemoteAddress string
+		RemoteIP      string
+
+		ClientCert *ClientCertificate
+		Proxy      *ProxyInfo
+		GeoIP      *ttutils.GeoIPInfo
 
 		// misc
 		Error      error
@@ -49,6 +61,37 @@ type (
 		End        time.Time
 	}
 
+	// Timeouts are the deadlines of each phase of a connection (0 means no deadline).
+	Timeouts struct {
+		Handshake time.Duration
+		Read      time.Duration
+		Process   time.Duration
+		Write     time.Duration
+	}
+
+	ClientCertificate struct {
+		Fingerprint string
+		Subject     string
+		Issuer      string
+		NotBefore   time.Time
+		NotAfter    time.Time
+		Verified    bool
+	}
+
+	// ProxyInfo is what a proxy tells about the client with the TLVs of the PROXY protocol v2,
+	// mostly when it terminates the TLS connection.
+	ProxyInfo struct {
+		SNI      string
+		ALPN     string
+		UniqueID string
+
+		SSL               bool
+		SSLVersion        string
+		SSLCipher         string
+		SSLClientCN       string
+		SSLClientVerified bool
+	}
+
 	ConfigInput struct {
 		InitialConn net.Conn
 		CurConn     net.Conn
@@ -56,10 +99,13 @@ type (
 
 		Logger *logrus.Entry
 
-		MaxqueryBytes int64
+		MaxQueryBytes int64
+		Timeouts      Timeouts
 
 		Config         *ttutils.ConfigRoot
 		Cache          func() ttcache.ICacheCache
+		FetchCache     func() *ttcache.Fetch
+		RateLimiter    func() *ttratelimit.RateLimiter
 		PrometheusFire func(*ttprom.PrometheusMetric) error
 	}
 )
@@ -96,12 +142,6 @@ func GetUUID(c net.Conn) string {
 }
 
 func NewConnection(connConfig *ConfigInput) *Connection {
-	// If we have a maximum amount to read, then setup a limit
-	var r io.Reader = connConfig.CurConn
-	if connConfig.MaxqueryBytes > 0 {
-		r = io.LimitReader(connConfig.CurConn, connConfig.MaxqueryBytes)
-	}
-
 	conn := &Connection{
 		InitialConn: connConfig.InitialConn,
 		CurConn:     connConfig.CurConn,
@@ -110,12 +150,17 @@ func NewConnection(connConfig *ConfigInput) *Connection {
 		Config:         connConfig.Config,
 		PrometheusFire: connConfig.PrometheusFire,
 		Cache:          connConfig.Cache,
+		FetchCache:     connConfig.FetchCache,
+		RateLimiter:    connConfig.RateLimiter,
 
 		Writer:        bufio.NewWriter(connConfig.CurConn),
-		Reader:        bufio.NewReader(r),
+		Reader:        bufio.NewReader(connConfig.CurConn),
+		Timeouts:      connConfig.Timeouts,
+		MaxQueryBytes: connConfig.MaxQueryBytes,
 		Start:         time.Now(),
 		LocalAddress:  connConfig.CurConn.LocalAddr().String(),
 		RemoteAddress: connConfig.CurConn.RemoteAddr().String(),
+		RemoteIP:      RemoteIP(connConfig.CurConn.RemoteAddr()),
 		UUID:          connConfig.UUID,
 		State:         CONNECTION_STATE_NEW,
 	}
@@ -123,6 +168,45 @@ func NewConnection(connConfig *ConfigInput) *Connection {
 	return conn
 }
 
+// RemoteIP returns the IP of a remote address, with the PROXY protocol
+// it's the IP of the client, not the one of the proxy.
+func RemoteIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// ErrQueryTooLarge is returned when a query is longer than MaxQueryBytes.
+var ErrQueryTooLarge = errors.New("query too large")
+
+func (c *Connection) SetReadTimeout(d time.Duration) error {
+	if d <= 0 || c.CurConn == nil {
+		return nil
+	}
+
+	return c.CurConn.SetReadDeadline(time.Now().Add(d))
+}
+
+func (c *Connection) SetWriteTimeout(d time.Duration) error {
+	if d <= 0 || c.CurConn == nil {
+		return nil
+	}
+
+	return c.CurConn.SetWriteDeadline(time.Now().Add(d))
+}
+
+// IsProcessDeadlineExceeded tells if the processing of the connection took too long.
+func (c *Connection) IsProcessDeadlineExceeded() bool {
+	return !c.ProcessDeadline.IsZero() && time.Now().After(c.ProcessDeadline)
+}
+
 func (c *Connection) Close() error {
 	return c.CurConn.Close()
 }
@@ -14,6 +14,14 @@ func (f *Handler) ServeConn(conn *ttconn.Connection) error {
 	return tthandler.SimpleTextServeConnHandlerDefaultServeConn(f, conn)
 }
 
+func (f *Handler) ServeConnError(conn *ttconn.Connection, code string) error {
+	return tthandler.SimpleTextServeConnHandlerDefaultServeConnError(f, conn, code)
+}
+
+func (f *Handler) ServeConnBusy(conn *ttconn.Connection) error {
+	return tthandler.SimpleTextServeConnHandlerDefaultServeConnBusy(f, conn)
+}
+
 func (f *Handler) ServeCrontab(conn *ttconn.Connection, route string, routeExtraData interface{}) error {
 	return tthandler.SimpleTextServeConnHandlerDefaultServeCrontab(f, conn, route, routeExtraData)
 }
@@ -28,19 +36,29 @@ func (f *Handler) ParseData(conn *ttconn.Connection, inbuf []byte) (string, inte
 		return "", nil, err
 	}
 
-	return query.Username, nil, nil
+	var data string
+
+	if query.Verbose {
+		data = "/W"
+	}
+
+	return query.Username, &data, nil
 }
 
 func (f *Handler) Process(conn *ttconn.Connection, route string, extraData interface{}, forceCacheUpdate bool) (output interface{}, err error) {
 
 	errCodeMap := make(map[string][]byte)
-	errCodeMap["200"] = []byte("OK (200)")
-	errCodeMap["404"] = []byte("Not found (404)")
-	errCodeMap["500"] = []byte("Internal Server Error (500)")
+	for code, msg := range tthandler.ReturnCodeMessages {
+		errCodeMap[code] = []byte(msg)
+	}
 
 	return tthandler.SimpleTextServeConnHandlerCustomProcess(f, conn, route, extraData, forceCacheUpdate, errCodeMap)
 }
 
+func (f *Handler) ErrorResponse(conn *ttconn.Connection, code string) (output interface{}) {
+	return tthandler.SimpleTextServeConnHandlerDefaultErrorResponse(conn, code)
+}
+
 func (f *Handler) PostProcess(conn *ttconn.Connection, route string, extraData interface{}, input interface{}) (output interface{}, err error) {
 
 	return input, nil
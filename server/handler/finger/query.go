@@ -8,6 +8,7 @@ import (
 type Query struct {
 	Username string   // Username, can be blank
 	Hostname []string // Hostname (zero or more), not used
+	Verbose  bool     // /W, the verbose output is asked
 }
 
 /*
@@ -28,13 +29,21 @@ var lineRegexp = regexp.MustCompile(`` +
 )
 
 func ParseQuery(line string) (*Query, error) {
+	var result Query
+
+	// {W}
+	if trimmed := strings.TrimLeft(line, " \t"); strings.HasPrefix(trimmed, "/W") {
+		if rest := strings.TrimPrefix(trimmed, "/W"); rest == "" || strings.IndexAny(rest[:1], " \t\r\n") == 0 {
+			result.Verbose = true
+			line = rest
+		}
+	}
+
 	values := findNamedMatches(lineRegexp, line)
 	if values == nil {
-		return &Query{}, nil
+		return &result, nil
 	}
 
-	var result Query
-
 	if username, ok := values["U"]; ok {
 		result.Username = username
 	}
@@ -23,6 +23,14 @@ func (f *Handler) ServeConn(conn *ttconn.Connection) error {
 	return tthandler.SimpleTextServeConnHandlerDefaultServeConn(f, conn)
 }
 
+func (f *Handler) ServeConnError(conn *ttconn.Connection, code string) error {
+	return tthandler.SimpleTextServeConnHandlerDefaultServeConnError(f, conn, code)
+}
+
+func (f *Handler) ServeConnBusy(conn *ttconn.Connection) error {
+	return tthandler.SimpleTextServeConnHandlerDefaultServeConnBusy(f, conn)
+}
+
 func (f *Handler) ServeCrontab(conn *ttconn.Connection, route string, routeExtraData interface{}) error {
 	return tthandler.SimpleTextServeConnHandlerDefaultServeCrontab(f, conn, route, routeExtraData)
 }
@@ -41,6 +49,8 @@ func (f *Handler) ParseData(conn *ttconn.Connection, inbuf []byte) (route string
 
 	if query.ExtData != "" {
 		data = query.ExtData
+	} else if query.Search != "" {
+		data = query.Search
 	}
 
 	return query.Selector, &data, nil
@@ -89,34 +99,36 @@ func (f *Handler) Process(conn *ttconn.Connection, route string, extraData inter
 	// normal handling
 	//
 	errCodeMap := make(map[string][]byte)
-
-	code200 := gopherItem{
-		Type:        INFO,
-		Description: "OK (200)",
-		Selector:    "",
-		Host:        "localhost",
-		Port:        "0",
+	for code := range tthandler.ReturnCodeMessages {
+		errCodeMap[code] = f.errorItem(code).Bytes()
 	}
-	code404 := gopherItem{
-		Type:        ERROR,
-		Description: "Not found (404)",
-		Selector:    "",
-		Host:        "localhost",
-		Port:        "0",
+
+	return tthandler.SimpleTextServeConnHandlerCustomProcess(f, conn, route, extraData, forceCacheUpdate, errCodeMap)
+}
+
+func (f *Handler) ErrorResponse(conn *ttconn.Connection, code string) (output interface{}) {
+	return f.errorItem(code).Bytes()
+}
+
+func (f *Handler) errorItem(code string) *gopherItem {
+	msg, ok := tthandler.ReturnCodeMessages[code]
+	if !ok {
+		msg = tthandler.ReturnCodeMessages["500"]
 	}
-	code500 := gopherItem{
+
+	gi := &gopherItem{
 		Type:        ERROR,
-		Description: "Internal Server Error (500)",
+		Description: msg,
 		Selector:    "",
 		Host:        "localhost",
 		Port:        "0",
 	}
 
-	errCodeMap["200"] = []byte(code200.String())
-	errCodeMap["404"] = []byte(code404.String())
-	errCodeMap["500"] = []byte(code500.String())
+	if code == "200" {
+		gi.Type = INFO
+	}
 
-	return tthandler.SimpleTextServeConnHandlerCustomProcess(f, conn, route, extraData, forceCacheUpdate, errCodeMap)
+	return gi
 }
 
 func (f *Handler) PostProcess(conn *ttconn.Connection, route string, extraData interface{}, input interface{}) (output interface{}, err error) {
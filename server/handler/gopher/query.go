@@ -8,6 +8,7 @@ import (
 type Query struct {
 	Selector string
 	ExtData  string
+	Search   string // the search terms (type 7), after a tab
 }
 
 var lineRegexp = regexp.MustCompile(`` +
@@ -20,13 +21,24 @@ var lineRegexp = regexp.MustCompile(`` +
 )
 
 func ParseQuery(line string) (*Query, error) {
+	var result Query
+
+	if i := strings.IndexByte(line, '\t'); i >= 0 {
+		result.Search = strings.TrimRight(line[i+1:], "\r\n")
+
+		// Gopher+ adds its own fields
+		if j := strings.IndexByte(result.Search, '\t'); j >= 0 {
+			result.Search = result.Search[:j]
+		}
+
+		line = line[:i]
+	}
+
 	values := findNamedMatches(lineRegexp, line)
 	if values == nil {
-		return &Query{}, nil
+		return &result, nil
 	}
 
-	var result Query
-
 	if sel, ok := values["Selector"]; ok && sel != "" {
 		result.Selector = sel
 	} else {
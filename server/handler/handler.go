@@ -9,6 +9,12 @@ type (
 		// what to do with a new connection
 		ServeConn(conn *ttconn.Connection) error
 
+		// what to do with a new connection that can't be served (busy, forbidden...)
+		ServeConnError(conn *ttconn.Connection, code string) error
+
+		// what to do with a new connection rejected as soon as it's accepted, the query isn't read
+		ServeConnBusy(conn *ttconn.Connection) error
+
 		// what to do with a new connection from the cron server
 		ServeCrontab(conn *ttconn.Connection, route string, routeExtraData interface{}) error
 
@@ -18,9 +24,28 @@ type (
 		// register new specific metrics
 		RegisterPrometheusMetrics() error
 	}
+
+	// IMaxQueryBytesHandler is implemented by the handlers declaring
+	// the maximum size of their queries.
+	IMaxQueryBytesHandler interface {
+		MaxQueryBytes() int
+	}
 )
 
 const (
 	CRLF = "\r\n"
 	TAB  = byte('\t')
 )
+
+// ReturnCodeMessages are the default messages of the return codes.
+var ReturnCodeMessages = map[string]string{
+	"200": "OK (200)",
+	"403": "Forbidden (403)",
+	"404": "Not found (404)",
+	"408": "Request Timeout (408)",
+	"413": "Query Too Large (413)",
+	"429": "Too Many Requests (429)",
+	"500": "Internal Server Error (500)",
+	"503": "Service Busy (503)",
+	"504": "Processing Timeout (504)",
+}
@@ -2,9 +2,13 @@ package handler
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"os"
 	"strings"
+	"time"
 
 	ttconn "github.com/tristan-weil/ttserver/server/connection"
 )
@@ -14,6 +18,10 @@ type (
 		// inherited from IServeConnHandler
 		ServeConn(conn *ttconn.Connection) error
 
+		ServeConnError(conn *ttconn.Connection, code string) error
+
+		ServeConnBusy(conn *ttconn.Connection) error
+
 		ServeCrontab(conn *ttconn.Connection, route string, routeExtraData interface{}) error
 
 		GetTemplatesFuncMap(conn *ttconn.Connection) (tplFunc map[string]interface{}, err error)
@@ -27,6 +35,9 @@ type (
 		PostProcess(conn *ttconn.Connection, route string, routeExtraData interface{}, input interface{}) (output interface{}, err error)
 
 		Write(conn *ttconn.Connection, output interface{}) (n int64, err error)
+
+		// the response sent when the connection can't be served (query too large, timeout...)
+		ErrorResponse(conn *ttconn.Connection, code string) (output interface{})
 	}
 )
 
@@ -36,37 +47,28 @@ type (
 
 func SimpleTextServeConnHandlerDefaultServeConn(h SimpleTextServeConnHandler, conn *ttconn.Connection) error {
 	// update conn
-	var domain string
-	var port string
-
-	addrSplit := strings.Split(conn.LocalAddress, ":")
-	if resp_domain, ok := conn.Config.Space.Handler.Parameters["response_domain"]; ok {
-		domain = resp_domain
-	} else if conn.SNI != "" {
-		domain = conn.SNI
-	} else if len(conn.Config.Space.Listener.Domains) > 0 {
-		domain = conn.Config.Space.Listener.Domains[0]
-	} else {
-		domain = addrSplit[0]
-	}
-
-	if resp_port, ok := conn.Config.Space.Handler.Parameters["response_port"]; ok {
-		port = resp_port
-	} else {
-		addrSplit := strings.Split(conn.LocalAddress, ":")
-		port = addrSplit[1]
-	}
-
-	conn.Domain = domain
-	conn.Port = port
+	conn.Domain, conn.Port = responseDomainPort(conn)
 
 	// read the query line
 	conn.Logger.Debugf("reading...")
 
 	conn.State = ttconn.CONNECTION_STATUS_READING
+	if err := conn.SetReadTimeout(conn.Timeouts.Read); err != nil {
+		conn.State = ttconn.CONNECTION_STATUS_READING_ERROR
+		return err
+	}
+
 	inbuf, err := h.Read(conn)
 	if err != nil {
 		conn.State = ttconn.CONNECTION_STATUS_READING_ERROR
+
+		var netErr net.Error
+		if errors.Is(err, ttconn.ErrQueryTooLarge) {
+			SimpleTextServeConnHandlerWriteError(h, conn, "413")
+		} else if errors.As(err, &netErr) && netErr.Timeout() {
+			SimpleTextServeConnHandlerWriteError(h, conn, "408")
+		}
+
 		return err
 	}
 
@@ -91,22 +93,46 @@ func SimpleTextServeConnHandlerDefaultServeConn(h SimpleTextServeConnHandler, co
 	conn.Logger = conn.Logger.
 		WithField("route", route)
 
+	// rate limit of the route
+	if !SimpleTextServeConnHandlerIsRouteRateAllowed(conn, route) {
+		conn.State = ttconn.CONNECTION_STATUS_PROCESSING_ERROR
+		SimpleTextServeConnHandlerWriteError(h, conn, "429")
+
+		return fmt.Errorf("rate limit reached for the route")
+	}
+
 	// process
 	conn.Logger.Debugf("processing...")
 
 	conn.State = ttconn.CONNECTION_STATUS_PROCESSING
+	if conn.Timeouts.Process > 0 {
+		conn.ProcessDeadline = time.Now().Add(conn.Timeouts.Process)
+	}
+
 	outdata, err := h.Process(conn, route, extraData, false)
 	if err != nil {
 		conn.State = ttconn.CONNECTION_STATUS_PROCESSING_ERROR
 		return err
 	}
 
+	if conn.IsProcessDeadlineExceeded() {
+		conn.State = ttconn.CONNECTION_STATUS_PROCESSING_ERROR
+		SimpleTextServeConnHandlerWriteError(h, conn, "504")
+
+		return fmt.Errorf("processing timeout (%s)", conn.Timeouts.Process)
+	}
+
 	conn.Logger.Debugf("processing... done!")
 
 	// write
 	conn.Logger.Debugf("writing...")
 
 	conn.State = ttconn.CONNECTION_STATUS_WRITING
+	if err := conn.SetWriteTimeout(conn.Timeouts.Write); err != nil {
+		conn.State = ttconn.CONNECTION_STATUS_WRITING_ERROR
+		return err
+	}
+
 	_, err = h.Write(conn, outdata)
 	if err != nil {
 		conn.State = ttconn.CONNECTION_STATUS_WRITING_ERROR
@@ -123,14 +149,18 @@ func SimpleTextServeConnHandlerDefaultServeConn(h SimpleTextServeConnHandler, co
 	return nil
 }
 
-func SimpleTextServeConnHandlerDefaultServeCrontab(h SimpleTextServeConnHandler, conn *ttconn.Connection, route string, routeExtraData interface{}) error {
-	// update conn
-	var domain string
-	var port string
+// responseDomainPort returns the domain and the port used by the responses, the same way
+// for the clients and the cron (see defaultCacheKey in the server's config).
+func responseDomainPort(conn *ttconn.Connection) (domain string, port string) {
+	addrSplit := strings.Split(conn.LocalAddress, ":")
 
-	addrSplit := strings.Split(*conn.Config.Space.Listener.Address, ":")
 	if resp_domain, ok := conn.Config.Space.Handler.Parameters["response_domain"]; ok {
 		domain = resp_domain
+	} else if conn.SNI != "" {
+		domain = conn.SNI
+	} else if conn.Proxy != nil && isListenerDomain(conn, conn.Proxy.SNI) {
+		// TLS terminated by the proxy
+		domain = conn.Proxy.SNI
 	} else if len(conn.Config.Space.Listener.Domains) > 0 {
 		domain = conn.Config.Space.Listener.Domains[0]
 	} else {
@@ -140,11 +170,65 @@ func SimpleTextServeConnHandlerDefaultServeCrontab(h SimpleTextServeConnHandler,
 	if resp_port, ok := conn.Config.Space.Handler.Parameters["response_port"]; ok {
 		port = resp_port
 	} else {
-		port = addrSplit[1]
+		port = addrSplit[len(addrSplit)-1]
+	}
+
+	return domain, port
+}
+
+// isListenerDomain tells if the domain is one of the domains of the listener.
+func isListenerDomain(conn *ttconn.Connection, domain string) bool {
+	for _, d := range conn.Config.Space.Listener.Domains {
+		if d == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SimpleTextServeConnHandlerIsRouteRateAllowed tells if the rate limit of the route (if any)
+// allows the connection to be served.
+func SimpleTextServeConnHandlerIsRouteRateAllowed(conn *ttconn.Connection, route string) bool {
+	if conn.RateLimiter == nil {
+		return true
+	}
+
+	rateLimiter := conn.RateLimiter()
+	if rateLimiter == nil {
+		return true
+	}
+
+	routeConfig := conn.Config.Space.GetRoute(route)
+	if routeConfig == nil {
+		return true
 	}
 
-	conn.Domain = domain
-	conn.Port = port
+	// the selectors of a regexp route share its limit
+	return rateLimiter.AllowRoute(conn.RemoteIP, routeConfig.Name, routeConfig.RateLimit)
+}
+
+func SimpleTextServeConnHandlerDefaultServeConnError(h SimpleTextServeConnHandler, conn *ttconn.Connection, code string) error {
+	// the query is read (and ignored) so that closing the connection
+	// doesn't reset it before the client gets the response
+	if err := conn.SetReadTimeout(conn.Timeouts.Read); err == nil {
+		_, _ = h.Read(conn)
+	}
+
+	SimpleTextServeConnHandlerWriteError(h, conn, code)
+
+	return nil
+}
+
+func SimpleTextServeConnHandlerDefaultServeConnBusy(h SimpleTextServeConnHandler, conn *ttconn.Connection) error {
+	SimpleTextServeConnHandlerWriteError(h, conn, "503")
+
+	return nil
+}
+
+func SimpleTextServeConnHandlerDefaultServeCrontab(h SimpleTextServeConnHandler, conn *ttconn.Connection, route string, routeExtraData interface{}) error {
+	// update conn, the local address is the one of the listener
+	conn.Domain, conn.Port = responseDomainPort(conn)
 
 	if _, err := h.Process(conn, route, routeExtraData, true); err != nil {
 		return err
@@ -165,14 +249,27 @@ func SimpleTextServeConnHandlerDefaultRead(conn *ttconn.Connection) (input []byt
 	scanner := bufio.NewScanner(conn.Reader)
 	scanner.Split(bufio.ScanLines)
 
+	// the buffer has to hold the line and its CRLF
+	if conn.MaxQueryBytes > 0 {
+		scanner.Buffer(make([]byte, 0, conn.MaxQueryBytes+2), int(conn.MaxQueryBytes)+2)
+	}
+
 	// first line
 	scanner.Scan()
 	line := scanner.Text()
 
 	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, ttconn.ErrQueryTooLarge
+		}
+
 		return nil, err
 	}
 
+	if conn.MaxQueryBytes > 0 && int64(len(line)) > conn.MaxQueryBytes {
+		return nil, ttconn.ErrQueryTooLarge
+	}
+
 	return []byte(line), nil
 }
 
@@ -203,3 +300,29 @@ func SimpleTextServeConnHandlerDefaultWrite(conn *ttconn.Connection, output inte
 
 	return n, err
 }
+
+func SimpleTextServeConnHandlerDefaultErrorResponse(conn *ttconn.Connection, code string) (output interface{}) {
+	msg, ok := ReturnCodeMessages[code]
+	if !ok {
+		msg = ReturnCodeMessages["500"]
+	}
+
+	return []byte(msg + CRLF)
+}
+
+// SimpleTextServeConnHandlerWriteError sends the error response of the handler,
+// the connection is not expected to be used after that.
+func SimpleTextServeConnHandlerWriteError(h SimpleTextServeConnHandler, conn *ttconn.Connection, code string) {
+	conn.ReturnCode = code
+	conn.Logger = conn.Logger.
+		WithField("code", code)
+
+	if err := conn.SetWriteTimeout(conn.Timeouts.Write); err != nil {
+		conn.Logger.Debugf("unable to send the error response -> %s", err)
+		return
+	}
+
+	if _, err := h.Write(conn, h.ErrorResponse(conn, code)); err != nil {
+		conn.Logger.Debugf("unable to send the error response -> %s", err)
+	}
+}
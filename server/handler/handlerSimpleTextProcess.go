@@ -2,8 +2,10 @@ package handler
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -26,6 +28,10 @@ type (
 		Port         string
 		LocalAddress string
 		LocalPort    string
+		Query        string
+		ClientCert   *ttconn.ClientCertificate
+		Proxy        *ttconn.ProxyInfo
+		GeoIP        *ttutils.GeoIPInfo
 
 		URI  string
 		Type string
@@ -42,6 +48,288 @@ type (
 
 var getFeedTimeout = 30 * time.Second
 
+// fetchTimeout returns the timeout of a fetch, bounded by the processing deadline.
+func fetchTimeout(conn *ttconn.Connection) time.Duration {
+	if conn.ProcessDeadline.IsZero() {
+		return getFeedTimeout
+	}
+
+	if left := time.Until(conn.ProcessDeadline); left < getFeedTimeout {
+		return left
+	}
+
+	return getFeedTimeout
+}
+
+// queryData returns the extra data of the query (the gopher search terms, the finger /W).
+func queryData(routeExtraData interface{}) string {
+	if data, ok := routeExtraData.(*string); ok && data != nil {
+		return *data
+	}
+
+	return ""
+}
+
+// isStale tells if the item has expired for less than the window (in seconds).
+func isStale(item *ttcache.Item, window *int) bool {
+	return item != nil && !item.Fresh.IsZero() &&
+		time.Since(item.Fresh) < time.Duration(ttutils.IntValue(window))*time.Second
+}
+
+// revalidating holds the cache keys being rendered again in the background.
+var revalidating sync.Map
+
+// revalidate renders the route again in the background, with a copy of the connection
+// as it is closed once the stale page is sent.
+func revalidate(
+	h SimpleTextServeConnHandler,
+	conn *ttconn.Connection,
+	route string,
+	routeConfig *ttutils.RouteConfig,
+	routeExtraData interface{},
+	errCodeMap map[string][]byte) {
+
+	key := cacheKey(conn, route, routeConfig, routeExtraData)
+
+	if _, loaded := revalidating.LoadOrStore(key, true); loaded {
+		return
+	}
+
+	revalidateConn := &ttconn.Connection{
+		Logger:         conn.Logger.WithField("connection", conn.UUID+":revalidate"),
+		PrometheusFire: conn.PrometheusFire,
+		Config:         conn.Config,
+		Cache:          conn.Cache,
+		FetchCache:     conn.FetchCache,
+		RateLimiter:    conn.RateLimiter,
+
+		Start:         time.Now(),
+		LocalAddress:  conn.LocalAddress,
+		RemoteAddress: conn.RemoteAddress,
+		RemoteIP:      conn.RemoteIP,
+		UUID:          conn.UUID + ":revalidate",
+
+		Domain: conn.Domain,
+		Port:   conn.Port,
+		SNI:    conn.SNI,
+
+		ClientCert: conn.ClientCert,
+		Proxy:      conn.Proxy,
+		GeoIP:      conn.GeoIP,
+
+		// the access has been checked with the initial connection
+		State: ttconn.CONNECTION_STATUS_CRON,
+	}
+
+	go func() {
+		defer revalidating.Delete(key)
+
+		revalidateConn.Logger.Debugf("revalidating...")
+
+		_, code, _ := doSimpleTextServeConnHandlerCustomProcess(h, revalidateConn, route, routeExtraData, true, errCodeMap)
+
+		revalidateConn.Logger.
+			WithField("code", code).
+			Debugf("revalidating... done!")
+	}()
+}
+
+type (
+	// renderFlight is a rendering in progress, shared by the concurrent requests of the same page.
+	renderFlight struct {
+		done chan struct{}
+		data []byte
+		code string
+		ok   bool
+	}
+
+	renderFlightKey struct {
+		cache ttcache.ICacheCache
+		key   string
+	}
+)
+
+// renderFlights holds the renderings in progress per cache key.
+var renderFlights sync.Map
+
+// joinRender returns the rendering in progress of the page, or registers a new one
+// when the request is the first (leader is true).
+func joinRender(conn *ttconn.Connection, key string) (flight *renderFlight, flightKey renderFlightKey, leader bool) {
+	flightKey = renderFlightKey{cache: conn.Cache(), key: key}
+
+	value, loaded := renderFlights.LoadOrStore(flightKey, &renderFlight{done: make(chan struct{})})
+
+	return value.(*renderFlight), flightKey, !loaded
+}
+
+// finish shares the result with the waiting requests, the files can't be shared.
+func (f *renderFlight) finish(flightKey renderFlightKey, data interface{}, code string) {
+	f.data, f.ok = data.([]byte)
+	f.code = code
+
+	renderFlights.Delete(flightKey)
+	close(f.done)
+}
+
+// wait returns the result of the rendering, ok is false if it can't be shared or took too long.
+func (f *renderFlight) wait(timeout time.Duration) (data []byte, code string, ok bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.done:
+		return f.data, f.code, f.ok
+	case <-timer.C:
+		return nil, "", false
+	}
+}
+
+// cacheKey returns the key of the route in the cache: the route then the variants of the query
+// configured for the route, as a query string. The route can't contain a tab.
+func cacheKey(conn *ttconn.Connection, route string, routeConfig *ttutils.RouteConfig, routeExtraData interface{}) string {
+	variants := url.Values{}
+
+	for _, part := range routeConfig.Cache.Key {
+		switch part {
+		case "domain":
+			variants.Set(part, conn.Domain)
+		case "port":
+			variants.Set(part, conn.Port)
+		case "query":
+			variants.Set(part, queryData(routeExtraData))
+		case "handler":
+			variants.Set(part, ttutils.StringValue(conn.Config.Space.Handler.Name))
+		case "clientcert":
+			if conn.ClientCert != nil {
+				variants.Set(part, fmt.Sprintf("%s/%t", conn.ClientCert.Fingerprint, conn.ClientCert.Verified))
+			}
+		case "geoip":
+			if conn.GeoIP != nil {
+				variants.Set(part, fmt.Sprintf("%s/%d", conn.GeoIP.Country, conn.GeoIP.ASN))
+			}
+		case "proxy":
+			// without the unique ID, which changes for each connection
+			if p := conn.Proxy; p != nil {
+				variants.Set(part, fmt.Sprintf("%s/%s/%t/%s/%s/%s/%t",
+					p.SNI, p.ALPN, p.SSL, p.SSLVersion, p.SSLCipher, p.SSLClientCN, p.SSLClientVerified))
+			}
+		}
+	}
+
+	if len(variants) == 0 {
+		return route
+	}
+
+	return route + "\t" + variants.Encode()
+}
+
+// fetchData returns the result of a fetch from the fetch cache. Once expired, it is fetched again
+// with the validators of the cached result (a conditional GET).
+func fetchData(conn *ttconn.Connection, fetchType string, uri string, expiration int) (interface{}, error) {
+	var fetchCache *ttcache.Fetch
+	if conn.FetchCache != nil {
+		fetchCache = conn.FetchCache()
+	}
+
+	if fetchCache == nil || fetchCache.IsDisabled() || expiration < 0 {
+		result, err := ttutils.Fetch(fetchTimeout(conn), fetchType, uri, "", "")
+		if err != nil {
+			return nil, err
+		}
+
+		return result.Data, nil
+	}
+
+	cached, ok := fetchCache.Get(fetchType, uri)
+	if ok && cached.IsFresh() {
+		conn.Logger.Tracef("fetching %s... found in the fetch cache", uri)
+
+		return cached.Data, nil
+	}
+
+	var etag, lastModified string
+	if ok {
+		etag = cached.ETag
+		lastModified = cached.LastModified
+	}
+
+	result, err := ttutils.Fetch(fetchTimeout(conn), fetchType, uri, etag, lastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &ttcache.FetchItem{
+		Type:         fetchType,
+		URI:          uri,
+		Data:         result.Data,
+		Size:         result.Size,
+		ETag:         result.ETag,
+		LastModified: result.LastModified,
+		Fetched:      time.Now(),
+	}
+
+	if result.NotModified {
+		conn.Logger.Tracef("fetching %s... not modified", uri)
+
+		item.Data = cached.Data
+		item.Size = cached.Size
+
+		// the validators may be omitted from a 304
+		if item.ETag == "" {
+			item.ETag = cached.ETag
+		}
+
+		if item.LastModified == "" {
+			item.LastModified = cached.LastModified
+		}
+	}
+
+	if expiration > 0 {
+		item.Fresh = item.Fetched.Add(time.Duration(expiration) * time.Second)
+	}
+
+	fetchCache.Set(item)
+
+	return item.Data, nil
+}
+
+// CacheKeyRoute returns the route of a key of the cache.
+func CacheKeyRoute(key string) string {
+	if i := strings.IndexByte(key, '\t'); i >= 0 {
+		return key[:i]
+	}
+
+	return key
+}
+
+func isErrorRoute(route string) bool {
+	return route == "403" || route == "404" || route == "500"
+}
+
+// isClientCertAllowed checks the client certificate requirements of a route.
+// The cron has no peer and is always allowed.
+func isClientCertAllowed(conn *ttconn.Connection, routeConfig *ttutils.RouteConfig) bool {
+	if routeConfig.ClientCert == nil || conn.State == ttconn.CONNECTION_STATUS_CRON {
+		return true
+	}
+
+	if conn.ClientCert == nil {
+		return !ttutils.BoolValue(routeConfig.ClientCert.Required) && len(routeConfig.ClientCert.Fingerprints) == 0
+	}
+
+	if len(routeConfig.ClientCert.Fingerprints) == 0 {
+		return true
+	}
+
+	for _, fingerprint := range routeConfig.ClientCert.Fingerprints {
+		if fingerprint == conn.ClientCert.Fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
 func SimpleTextServeConnHandlerCustomProcess(
 	h SimpleTextServeConnHandler,
 	conn *ttconn.Connection,
@@ -54,10 +342,10 @@ func SimpleTextServeConnHandlerCustomProcess(
 	// error map
 	//
 	if errCodeMap == nil {
-		errCodeMap := make(map[string][]byte)
-		errCodeMap["200"] = []byte("OK (200)")
-		errCodeMap["404"] = []byte("Not found (404)")
-		errCodeMap["500"] = []byte("Internal Server Error (500)")
+		errCodeMap = make(map[string][]byte)
+		for code, msg := range ReturnCodeMessages {
+			errCodeMap[code] = []byte(msg)
+		}
 	}
 
 	outbuf, returnCode, cacheStatus := doSimpleTextServeConnHandlerCustomProcess(h, conn, route, extraData, forceCacheUpdate, errCodeMap)
@@ -88,6 +376,7 @@ func doSimpleTextServeConnHandlerCustomProcess(
 		returnData        interface{}
 		returnCode        = "200"
 		returnCacheStatus = "NOCACHE"
+		staleData         *ttcache.Item
 		err               error
 		tpl               *template.Template
 		templateData      = make(map[string]interface{})
@@ -115,7 +404,7 @@ func doSimpleTextServeConnHandlerCustomProcess(
 	if routeConfig == nil {
 		conn.Logger.Errorf("unable to find a configuration for this route")
 
-		if route == "404" || route == "500" {
+		if isErrorRoute(route) {
 			returnData = errCodeMap[route]
 			returnData = append(returnData.([]byte), []byte(CRLF)...)
 			returnCode = route
@@ -127,6 +416,33 @@ func doSimpleTextServeConnHandlerCustomProcess(
 		goto GOTO_NO_CACHE
 	}
 
+	/*
+	 ********************************************************************************
+	 *
+	 * Access control
+	 *
+	 ********************************************************************************
+	 */
+
+	// the error routes are always allowed: they render the refusals
+	if !isErrorRoute(route) && conn.State != ttconn.CONNECTION_STATUS_CRON && !routeConfig.ACL.IsAllowed(conn.RemoteIP, conn.GeoIP) {
+		conn.Logger.Errorf("remote address refused by the acl of this route")
+
+		returnData, returnCode, returnCacheStatus = doSimpleTextServeConnHandlerCustomProcess(h, conn, "403", routeExtraData, false, errCodeMap)
+		returnCode = "403"
+
+		goto GOTO_NO_CACHE
+	}
+
+	if !isErrorRoute(route) && !isClientCertAllowed(conn, routeConfig) {
+		conn.Logger.Errorf("client certificate refused for this route")
+
+		returnData, returnCode, returnCacheStatus = doSimpleTextServeConnHandlerCustomProcess(h, conn, "403", routeExtraData, false, errCodeMap)
+		returnCode = "403"
+
+		goto GOTO_NO_CACHE
+	}
+
 	/*
 	 ********************************************************************************
 	 *
@@ -141,8 +457,8 @@ func doSimpleTextServeConnHandlerCustomProcess(
 	if !forceCacheUpdate && conn.Cache().IsEnabled() {
 		conn.Logger.Tracef("checking cache...")
 
-		cachedData, ok := conn.CacheGet(route)
-		if ok {
+		cachedData, ok := conn.CacheGet(cacheKey(conn, route, routeConfig, routeExtraData))
+		if ok && cachedData.IsFresh() {
 			returnData = cachedData.Item.([]byte)
 			returnCode = cachedData.Code
 			returnCacheStatus = "HIT"
@@ -152,9 +468,68 @@ func doSimpleTextServeConnHandlerCustomProcess(
 			goto GOTO_NO_CACHE
 		}
 
+		if ok {
+			staleData = cachedData
+
+			// served while rendered again in the background
+			if isStale(staleData, routeConfig.Cache.StaleWhileRevalidate) {
+				returnData = staleData.Item.([]byte)
+				returnCode = staleData.Code
+				returnCacheStatus = "STALE"
+
+				conn.Logger.Tracef("checking cache... found stale!")
+
+				revalidate(h, conn, route, routeConfig, routeExtraData, errCodeMap)
+
+				goto GOTO_NO_CACHE
+			}
+		}
+
 		conn.Logger.Tracef("checking cache... not found!")
 	}
 
+	// a forced rendering keeps the current page if it fails
+	if forceCacheUpdate && conn.Cache().IsEnabled() {
+		if cachedData, ok := conn.CacheGet(cacheKey(conn, route, routeConfig, routeExtraData)); ok {
+			staleData = cachedData
+		}
+	}
+
+	//
+	// the concurrent renderings of the page wait for the first one
+	//
+	if conn.Cache().IsEnabled() {
+		flight, flightKey, leader := joinRender(conn, cacheKey(conn, route, routeConfig, routeExtraData))
+
+		if leader {
+			defer func() {
+				flight.finish(flightKey, returnData, returnCode)
+			}()
+		} else {
+			conn.Logger.Tracef("waiting for the rendering in progress...")
+
+			if data, code, ok := flight.wait(fetchTimeout(conn)); ok {
+				returnData = data
+				returnCode = code
+				returnCacheStatus = "COALESCED"
+
+				conn.Logger.Tracef("waiting for the rendering in progress... done!")
+
+				if err := conn.PrometheusFire(&ttprom.PrometheusMetric{
+					Metric: ttprom.PrometheusRouteCoalescedCounter,
+					Labels: []string{route},
+					Action: "inc",
+				}); err != nil {
+					conn.Logger.Errorf("firing prometheus failed -> %s", err)
+				}
+
+				goto GOTO_NO_CACHE
+			}
+
+			conn.Logger.Debugf("unable to wait for the rendering in progress, rendering the page")
+		}
+	}
+
 	//
 	// building path
 	//
@@ -184,7 +559,7 @@ func doSimpleTextServeConnHandlerCustomProcess(
 	if !isATemplateFile && !isAFile {
 		conn.Logger.Errorf("not found on FS: %s%s", filePath, templateFilePath)
 
-		if route == "404" || route == "500" {
+		if isErrorRoute(route) {
 			returnData = errCodeMap[route]
 			returnData = append(returnData.([]byte), []byte(CRLF)...)
 			returnCode = route
@@ -220,7 +595,7 @@ func doSimpleTextServeConnHandlerCustomProcess(
 			goto GOTO_NO_CACHE
 		} else {
 			if _, err = io.Copy(buf, file); err != nil {
-				conn.Logger.Errorf("unable to open %s", file)
+				conn.Logger.Errorf("unable to open %s", filePath)
 
 				returnData, returnCode, returnCacheStatus = doSimpleTextServeConnHandlerCustomProcess(h, conn, "500", routeExtraData, false, errCodeMap)
 				returnCode = "500"
@@ -287,7 +662,7 @@ func doSimpleTextServeConnHandlerCustomProcess(
 	if err != nil {
 		conn.Logger.Errorf("template parsing error -> %s", err)
 
-		if route == "404" || route == "500" {
+		if isErrorRoute(route) {
 			returnData = errCodeMap[route]
 			returnData = append(returnData.([]byte), []byte(CRLF)...)
 			returnCode = route
@@ -311,6 +686,10 @@ func doSimpleTextServeConnHandlerCustomProcess(
 		Port:         conn.Port,
 		LocalAddress: localAddr,
 		LocalPort:    localPort,
+		Query:        queryData(routeExtraData),
+		ClientCert:   conn.ClientCert,
+		Proxy:        conn.Proxy,
+		GeoIP:        conn.GeoIP,
 	}
 
 	if routeConfig.Fetch != nil {
@@ -357,30 +736,11 @@ func doSimpleTextServeConnHandlerCustomProcess(
 			}
 
 			switch fetchType {
-			case "html":
-				poolItems = append(poolItems, &simpleTextHandlerFetcher{
-					fetcher:   func(url string) (interface{}, error) { return ttutils.GetURL(getFeedTimeout, url) },
-					name:      fetchName,
-					fetchType: fetchType,
-					uri:       fetchURL,
-				})
-			case "feed":
-				poolItems = append(poolItems, &simpleTextHandlerFetcher{
-					fetcher:   func(url string) (interface{}, error) { return ttutils.GetFeed(getFeedTimeout, url) },
-					name:      fetchName,
-					fetchType: fetchType,
-					uri:       fetchURL,
-				})
-			case "json":
-				poolItems = append(poolItems, &simpleTextHandlerFetcher{
-					fetcher:   func(url string) (interface{}, error) { return ttutils.GetJSON(getFeedTimeout, url) },
-					name:      fetchName,
-					fetchType: fetchType,
-					uri:       fetchURL,
-				})
-			case "prometheus":
+			case "html", "feed", "json", "prometheus":
+				expiration := ttutils.IntValue(fetchItem.Expiration)
+
 				poolItems = append(poolItems, &simpleTextHandlerFetcher{
-					fetcher:   func(url string) (interface{}, error) { return ttutils.GetPrometheus(getFeedTimeout, url) },
+					fetcher:   func(url string) (interface{}, error) { return fetchData(conn, fetchType, url, expiration) },
 					name:      fetchName,
 					fetchType: fetchType,
 					uri:       fetchURL,
@@ -422,7 +782,7 @@ func doSimpleTextServeConnHandlerCustomProcess(
 	if err != nil {
 		conn.Logger.Errorf("template execution error -> %s", err)
 
-		if route == "404" || route == "500" {
+		if isErrorRoute(route) {
 			returnData = errCodeMap[route]
 			returnData = append(returnData.([]byte), []byte(CRLF)...)
 			returnCode = route
@@ -446,7 +806,7 @@ func doSimpleTextServeConnHandlerCustomProcess(
 	if err != nil {
 		conn.Logger.Errorf("template post-processing error -> %s", err)
 
-		if route == "404" || route == "500" {
+		if isErrorRoute(route) {
 			returnData = errCodeMap[route]
 			returnData = append(returnData.([]byte), []byte(CRLF)...)
 			returnCode = route
@@ -461,31 +821,57 @@ func doSimpleTextServeConnHandlerCustomProcess(
 	// no cache if unable to fetch remote data
 	for _, v := range templateData {
 		if v == nil {
+			if isStale(staleData, routeConfig.Cache.StaleIfError) {
+				goto GOTO_SERVE_STALE
+			}
+
 			goto GOTO_NO_CACHE
 		}
 	}
 
 GOTO_ADD_TO_CACHE:
+	if returnCode == "500" && isStale(staleData, routeConfig.Cache.StaleIfError) {
+		goto GOTO_SERVE_STALE
+	}
+
 	if conn.Cache().IsEnabled() {
 		conn.Logger.Tracef("adding to cache")
 
-		if forceCacheUpdate {
+		var (
+			expiration = time.Duration(ttutils.IntValue(routeConfig.Cache.Expiration)) * time.Second
+			stored     = expiration
+			fresh      time.Time
+		)
+
+		// kept longer in the cache to be served stale
+		if expiration > 0 {
+			fresh = time.Now().Add(expiration)
+			stored += time.Duration(ttutils.IntValue(routeConfig.Cache.StaleWhileRevalidate)) * time.Second
+
+			if staleIfError := expiration + time.Duration(ttutils.IntValue(routeConfig.Cache.StaleIfError))*time.Second; staleIfError > stored {
+				stored = staleIfError
+			}
+		}
+
+		if forceCacheUpdate || staleData != nil {
 			err = conn.CacheReplaceIfExists(
-				route,
+				cacheKey(conn, route, routeConfig, routeExtraData),
 				&ttcache.Item{
-					Item: returnData,
-					Code: returnCode,
+					Item:  returnData,
+					Code:  returnCode,
+					Fresh: fresh,
 				},
-				time.Duration(ttutils.IntValue(routeConfig.Cache.Expiration))*time.Second,
+				stored,
 			)
 		} else {
 			err = conn.CacheAdd(
-				route,
+				cacheKey(conn, route, routeConfig, routeExtraData),
 				&ttcache.Item{
-					Item: returnData,
-					Code: returnCode,
+					Item:  returnData,
+					Code:  returnCode,
+					Fresh: fresh,
 				},
-				time.Duration(ttutils.IntValue(routeConfig.Cache.Expiration))*time.Second,
+				stored,
 			)
 		}
 
@@ -504,6 +890,15 @@ GOTO_ADD_TO_CACHE:
 		conn.Logger.Tracef("not adding to cache")
 	}
 
+	goto GOTO_NO_CACHE
+
+GOTO_SERVE_STALE:
+	conn.Logger.Warnf("rendering failed, serving the stale page")
+
+	returnData = staleData.Item.([]byte)
+	returnCode = staleData.Code
+	returnCacheStatus = "STALE"
+
 GOTO_NO_CACHE:
 	//
 	// the end
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"testing"
+
+	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+func TestCacheKey(t *testing.T) {
+	query := "search"
+
+	newConn := func() *ttconn.Connection {
+		return &ttconn.Connection{
+			Config: &ttutils.ConfigRoot{
+				Space: &ttutils.SpaceConfig{
+					Handler: &ttutils.HandlerConfig{Name: ttutils.String("gopher")},
+				},
+			},
+			Domain: "example.org",
+			Port:   "70",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		key    []string
+		conn   func(*ttconn.Connection)
+		extra  interface{}
+		wanted string
+	}{
+		{"no variant", nil, nil, nil, "index"},
+		{"domain and port", []string{"domain", "port"}, nil, nil, "index\tdomain=example.org&port=70"},
+		{"query", []string{"query"}, nil, &query, "index\tquery=search"},
+		{"no query", []string{"query"}, nil, nil, "index\tquery="},
+		{"handler", []string{"handler"}, nil, nil, "index\thandler=gopher"},
+		{
+			"clientcert", []string{"clientcert"},
+			func(c *ttconn.Connection) {
+				c.ClientCert = &ttconn.ClientCertificate{Fingerprint: "abcd", Verified: true}
+			},
+			nil, "index\tclientcert=abcd%2Ftrue",
+		},
+		{"no clientcert", []string{"clientcert"}, nil, nil, "index"},
+		{
+			"geoip", []string{"geoip"},
+			func(c *ttconn.Connection) { c.GeoIP = &ttutils.GeoIPInfo{Country: "FR", ASN: 12322} },
+			nil, "index\tgeoip=FR%2F12322",
+		},
+		{
+			"proxy without the unique id", []string{"proxy"},
+			func(c *ttconn.Connection) {
+				c.Proxy = &ttconn.ProxyInfo{SNI: "example.org", UniqueID: "1234", SSL: true, SSLVersion: "TLSv1.3"}
+			},
+			nil, "index\tproxy=example.org%2F%2Ftrue%2FTLSv1.3%2F%2F%2Ffalse",
+		},
+		{"unknown part", []string{"route"}, nil, nil, "index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newConn()
+			if tt.conn != nil {
+				tt.conn(conn)
+			}
+
+			routeConfig := &ttutils.RouteConfig{Cache: &ttutils.RouteCacheConfig{Key: tt.key}}
+
+			got := cacheKey(conn, "index", routeConfig, tt.extra)
+			if got != tt.wanted {
+				t.Errorf("cacheKey() = %q, wanted %q", got, tt.wanted)
+			}
+
+			if route := CacheKeyRoute(got); route != "index" {
+				t.Errorf("CacheKeyRoute(%q) = %q, wanted %q", got, route, "index")
+			}
+		})
+	}
+}
+
+func TestCacheKeyVariants(t *testing.T) {
+	routeConfig := &ttutils.RouteConfig{Cache: &ttutils.RouteCacheConfig{Key: []string{"clientcert"}}}
+
+	conn := &ttconn.Connection{}
+	anonymous := cacheKey(conn, "index", routeConfig, nil)
+
+	conn.ClientCert = &ttconn.ClientCertificate{Fingerprint: "abcd", Verified: false}
+	unverified := cacheKey(conn, "index", routeConfig, nil)
+
+	conn.ClientCert.Verified = true
+	verified := cacheKey(conn, "index", routeConfig, nil)
+
+	if anonymous == unverified || unverified == verified || anonymous == verified {
+		t.Errorf("the keys aren't distinct: %q, %q, %q", anonymous, unverified, verified)
+	}
+}
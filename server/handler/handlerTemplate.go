@@ -15,6 +15,8 @@ import (
 	gp_table "github.com/jedib0t/go-pretty/table"
 	gp_text "github.com/jedib0t/go-pretty/text"
 	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	ttcache "github.com/tristan-weil/ttserver/svc/cache"
+	ttratelimit "github.com/tristan-weil/ttserver/svc/ratelimit"
 	ttversion "github.com/tristan-weil/ttserver/version"
 	"golang.org/x/text/runes"
 	"golang.org/x/text/transform"
@@ -82,6 +84,44 @@ func ServeConnHandlerCommonGetTextTemplatesFuncMap(c *ttconn.Connection) (map[st
 			return fmt.Sprintf("%s", ttversion.BuildDate)
 		},
 
+		"ratelimit_bans": func() []ttratelimit.Ban {
+			if c.RateLimiter == nil || c.RateLimiter() == nil {
+				return nil
+			}
+
+			return c.RateLimiter().Bans()
+		},
+
+		"fetch_cache": func() []*ttcache.FetchItem {
+			if c.FetchCache == nil || c.FetchCache() == nil {
+				return nil
+			}
+
+			return c.FetchCache().Items()
+		},
+
+		"cache_stats": func() []ttcache.Stats {
+			var stats []ttcache.Stats
+
+			if c.Cache != nil && c.Cache() != nil {
+				stats = append(stats, c.Cache().Stats())
+			}
+
+			if c.FetchCache != nil && c.FetchCache() != nil {
+				stats = append(stats, c.FetchCache().Stats())
+			}
+
+			return stats
+		},
+
+		"cache_keys": func() []ttcache.KeyInfo {
+			if c.Cache == nil || c.Cache() == nil {
+				return nil
+			}
+
+			return c.Cache().Keys()
+		},
+
 		"bitcoincoreServices": func(flags interface{}) []string {
 			services := map[string]uint64{
 				"NONE":            0,
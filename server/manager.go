@@ -54,6 +54,7 @@ func NewManager(ctx context.Context, serveConnHandlers map[string]tthandler.ISer
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGUSR1,
+		syscall.SIGUSR2,
 	)
 
 	return &Manager{
@@ -174,6 +175,15 @@ func (m *Manager) Start() error {
 
 	m.spaceServeChan <- true
 
+	// started by an upgrade
+	ttutils.CloseInheritedListeners()
+
+	if err := notifyUpgradeReady(); err != nil {
+		m.logger.
+			WithField("svc", "manager").
+			Error(err)
+	}
+
 	//
 	// Signal handling loop
 	//
@@ -193,6 +203,7 @@ func (m *Manager) Start() error {
 						Infof("flushing space's' cache...")
 
 					m.space.GetCache().Flush()
+					m.space.Warmup()
 
 					m.logger.
 						WithField("svc", "manager").
@@ -215,6 +226,25 @@ func (m *Manager) Start() error {
 				if !m.space.IsServing() {
 					m.spaceServeChan <- true
 				}
+			case syscall.SIGUSR2:
+				m.logger.
+					WithField("svc", "manager").
+					Infof("upgrading...")
+
+				if err := m.upgrade(); err != nil {
+					m.logger.
+						WithField("svc", "manager").
+						Errorf("unable to upgrade, still serving -> %s", err)
+
+					continue
+				}
+
+				m.logger.
+					WithField("svc", "manager").
+					Infof("upgrading... done! draining the connections...")
+
+				m.Shutdown()
+				return
 			case syscall.SIGTERM:
 				fallthrough
 			case syscall.SIGINT:
@@ -325,12 +355,15 @@ func (m *Manager) initialize() error {
 	//
 	if m.prometheusServer == nil || !m.prometheusServer.IsInitialized() {
 		promConfig := &ttprom.PrometheusConfigInput{
-			Config: m.config,
-			Logger: m.logger.WithField("svc", "prometheus"),
+			Config:       m.config,
+			AdminHandler: m.adminHandler(),
+			Logger:       m.logger.WithField("svc", "prometheus"),
 		}
 
 		m.prometheusServer = ttprom.NewPrometheus(promConfig)
-		m.prometheusServer.Initialize()
+		if err := m.prometheusServer.Initialize(); err != nil {
+			return fmt.Errorf("unable to initialize prometheus: %s", err)
+		}
 
 		go func() {
 			err := m.prometheusServer.Start()
@@ -414,23 +447,29 @@ func (m *Manager) reset() error {
 }
 
 func (m *Manager) Shutdown() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	m.logger.
 		WithField("svc", "manager").
 		Infof("stopping...")
 
 	// space
-	if m.space != nil {
-		if err := m.space.Shutdown(); err != nil {
+	// without the lock: the connections being drained still fire the metrics
+	m.mu.RLock()
+	space := m.space
+	m.mu.RUnlock()
+
+	if space != nil {
+		if err := space.Shutdown(); err != nil {
 			m.logger.
 				WithField("svc", "manager").
 				Errorf("unable to shutdown space: %s", err)
 		}
-		m.space = nil
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.space = nil
+
 	// prometheus
 	if m.prometheusServer != nil {
 		if err := m.prometheusServer.Shutdown(); err != nil {
@@ -3,14 +3,20 @@ package space
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/sirupsen/logrus"
 	tthandler "github.com/tristan-weil/ttserver/server/handler"
 	ttcache "github.com/tristan-weil/ttserver/svc/cache"
 	ttcron "github.com/tristan-weil/ttserver/svc/cron"
+	ttgeoip "github.com/tristan-weil/ttserver/svc/geoip"
 	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttratelimit "github.com/tristan-weil/ttserver/svc/ratelimit"
 	tttcpl "github.com/tristan-weil/ttserver/svc/tcplistener"
+	ttwarmup "github.com/tristan-weil/ttserver/svc/warmup"
 	ttutils "github.com/tristan-weil/ttserver/utils"
 )
 
@@ -23,8 +29,18 @@ type (
 
 		cron        *ttcron.CronCron
 		cache       ttcache.ICacheCache
+		fetchCache  *ttcache.Fetch
+		rateLimiter *ttratelimit.RateLimiter
+		geoIP       *ttgeoip.GeoIP
+		warmup      *ttwarmup.Warmup
 		tcpListener *tttcpl.TCPListener
 
+		// statsCancel stops the export of the statistics of the cache stores,
+		// statsLast is the previous export of each store (see fireCacheStats)
+		statsCancel context.CancelFunc
+		statsLast   map[string]ttcache.Stats
+		muStats     sync.Mutex
+
 		context       context.Context
 		contextCancel context.CancelFunc
 		isServing     ttutils.AtomicBool
@@ -63,6 +79,11 @@ func (s *Space) Initialize() error {
 	s.logger.
 		Debugf("creating...")
 
+	//
+	// routes
+	//
+	s.watchRoutesCache(s.config)
+
 	//
 	// cache
 	//
@@ -71,8 +92,24 @@ func (s *Space) Initialize() error {
 
 		if s.config.Space.Cache.Memory != nil {
 			cache = ttcache.NewCacheMemory(&ttcache.MemoryConfigInput{
+				Expiration:     ttutils.IntValue(s.config.Space.Cache.Expiration),
+				Cleanup:        ttutils.IntValue(s.config.Space.Cache.Memory.Cleanup),
+				MaxEntries:     ttutils.IntValue(s.config.Space.Cache.Memory.MaxEntries),
+				MaxBytes:       ttutils.IntValue(s.config.Space.Cache.Memory.MaxBytes),
+				PrometheusFire: s.prometheusFire,
+				Logger:         s.logger.WithField("space-svc", "cache"),
+			})
+		} else if s.config.Space.Cache.Disk != nil {
+			cache = ttcache.NewCacheDisk(&ttcache.DiskConfigInput{
+				Path:       ttutils.StringValue(s.config.Space.Cache.Disk.Path),
+				Expiration: ttutils.IntValue(s.config.Space.Cache.Expiration),
+				Cleanup:    ttutils.IntValue(s.config.Space.Cache.Disk.Cleanup),
+				Logger:     s.logger.WithField("space-svc", "cache"),
+			})
+		} else if s.config.Space.Cache.Redis != nil {
+			cache = ttcache.NewCacheRedis(&ttcache.RedisConfigInput{
+				Config:     s.config.Space.Cache.Redis,
 				Expiration: ttutils.IntValue(s.config.Space.Cache.Expiration),
-				Cleanup:    ttutils.IntValue(s.config.Space.Cache.Memory.Cleanup),
 				Logger:     s.logger.WithField("space-svc", "cache"),
 			})
 		}
@@ -83,6 +120,58 @@ func (s *Space) Initialize() error {
 		}
 	}
 
+	//
+	// fetch cache
+	//
+	if s.fetchCache == nil {
+		s.fetchCache = ttcache.NewCacheFetch(&ttcache.FetchConfigInput{
+			Expiration:     ttutils.IntValue(s.config.Space.Cache.Fetch.Expiration),
+			Cleanup:        ttutils.IntValue(s.config.Space.Cache.Fetch.Cleanup),
+			MaxEntries:     ttutils.IntValue(s.config.Space.Cache.Fetch.MaxEntries),
+			MaxBytes:       ttutils.IntValue(s.config.Space.Cache.Fetch.MaxBytes),
+			PrometheusFire: s.prometheusFire,
+			Logger:         s.logger.WithField("space-svc", "fetchcache"),
+		})
+
+		s.fetchCache.Start()
+	}
+
+	//
+	// cache stats
+	//
+	if s.statsCancel == nil && ttutils.IntValue(s.config.Space.Cache.Stats) > 0 {
+		s.startCacheStats(ttutils.IntValue(s.config.Space.Cache.Stats))
+	}
+
+	//
+	// rate limiter
+	//
+	if s.rateLimiter == nil {
+		s.rateLimiter = ttratelimit.NewRateLimiter(&ttratelimit.ConfigInput{
+			Config:         s.config,
+			PrometheusFire: s.prometheusFire,
+			Logger:         s.logger.WithField("space-svc", "ratelimit"),
+		})
+
+		s.rateLimiter.Start()
+	}
+
+	//
+	// geoip
+	//
+	if s.geoIP == nil {
+		geoIP := ttgeoip.NewGeoIP(&ttgeoip.ConfigInput{
+			Config: s.config,
+			Logger: s.logger.WithField("space-svc", "geoip"),
+		})
+
+		if err := geoIP.Initialize(); err != nil {
+			return err
+		}
+
+		s.geoIP = geoIP
+	}
+
 	//
 	// cron
 	//
@@ -90,6 +179,7 @@ func (s *Space) Initialize() error {
 		cronInst, err := ttcron.NewCron(&ttcron.ConfigInput{
 			Config:           s.config,
 			Cache:            s.GetCache,
+			FetchCache:       s.GetFetchCache,
 			Logger:           s.logger.WithField("space-svc", "cron"),
 			ServeConnHandler: s.serveConnHandler,
 			PrometheusFire:   s.prometheusFire,
@@ -102,6 +192,22 @@ func (s *Space) Initialize() error {
 		s.cron = cronInst
 	}
 
+	//
+	// warm-up
+	//
+	if s.warmup == nil && s.config.Space.Cache.Warmup != nil {
+		s.warmup = ttwarmup.NewWarmup(&ttwarmup.ConfigInput{
+			Config:           s.config,
+			Cache:            s.GetCache,
+			FetchCache:       s.GetFetchCache,
+			Logger:           s.logger.WithField("space-svc", "warmup"),
+			ServeConnHandler: s.serveConnHandler,
+			PrometheusFire:   s.prometheusFire,
+		})
+
+		s.warmup.Start()
+	}
+
 	//
 	// listener
 	//
@@ -109,6 +215,9 @@ func (s *Space) Initialize() error {
 		s.tcpListener = tttcpl.NewTCPListener(&tttcpl.TCPListenerConfigInput{
 			Config:           s.config,
 			Cache:            s.GetCache,
+			FetchCache:       s.GetFetchCache,
+			RateLimiter:      s.GetRateLimiter,
+			GeoIP:            s.GetGeoIP,
 			ServeConnHandler: s.serveConnHandler,
 			PrometheusFire:   s.prometheusFire,
 			Logger:           s.logger.WithField("space-svc", "listener("+ttutils.StringValue(s.config.Space.Listener.Address)+")"),
@@ -137,6 +246,22 @@ func (s *Space) Reset(newConfig *ttutils.ConfigRoot) (*Space, error) {
 	s.logger.
 		Infof("reloading...")
 
+	//
+	// routes
+	//
+	s.watchRoutesCache(newConfig)
+
+	//
+	// geoip
+	//
+	if s.geoIP != nil {
+		if reset, err := s.geoIP.Reset(newConfig); err != nil {
+			return s, err
+		} else {
+			s.geoIP = reset
+		}
+	}
+
 	//
 	// listener
 	//
@@ -158,18 +283,76 @@ func (s *Space) Reset(newConfig *ttutils.ConfigRoot) (*Space, error) {
 		if _, ok := s.cache.(*ttcache.Memory); ok {
 			if newConfig.Space.Cache.Memory == nil ||
 				(s.cache.(*ttcache.Memory).Expiration != ttutils.IntValue(newConfig.Space.Cache.Expiration)) ||
-				(s.cache.(*ttcache.Memory).Cleanup != ttutils.IntValue(newConfig.Space.Cache.Memory.Cleanup)) {
+				(s.cache.(*ttcache.Memory).Cleanup != ttutils.IntValue(newConfig.Space.Cache.Memory.Cleanup)) ||
+				(s.cache.(*ttcache.Memory).MaxEntries != ttutils.IntValue(newConfig.Space.Cache.Memory.MaxEntries)) ||
+				(s.cache.(*ttcache.Memory).MaxBytes != ttutils.IntValue(newConfig.Space.Cache.Memory.MaxBytes)) {
 
 				s.cache.Shutdown()
 				s.cache = nil
 			} else {
 				s.cache.Flush()
 			}
+		} else if _, ok := s.cache.(*ttcache.Disk); ok {
+			if newConfig.Space.Cache.Disk == nil ||
+				(s.cache.(*ttcache.Disk).Expiration != ttutils.IntValue(newConfig.Space.Cache.Expiration)) ||
+				(s.cache.(*ttcache.Disk).Cleanup != ttutils.IntValue(newConfig.Space.Cache.Disk.Cleanup)) ||
+				(s.cache.(*ttcache.Disk).Path != ttutils.StringValue(newConfig.Space.Cache.Disk.Path)) {
+
+				// the items were stored with the previous configuration
+				s.cache.Flush()
+				s.cache.Shutdown()
+				s.cache = nil
+			}
+
+			// otherwise the store is kept, it's only flushed by SIGUSR1 or the admin flush
+		} else if _, ok := s.cache.(*ttcache.Redis); ok {
+			if newConfig.Space.Cache.Redis == nil ||
+				(s.cache.(*ttcache.Redis).Expiration != ttutils.IntValue(newConfig.Space.Cache.Expiration)) ||
+				!cmp.Equal(s.cache.(*ttcache.Redis).Config, newConfig.Space.Cache.Redis) {
+
+				s.cache.Shutdown()
+				s.cache = nil
+			}
+
+			// the store is shared with the other instances, it's never flushed by a reload,
+			// only by SIGUSR1 or the admin flush
 		} else {
 			s.cache.Flush()
 		}
 	}
 
+	//
+	// fetch cache
+	//
+	if s.fetchCache != nil {
+		if s.fetchCache.Expiration != ttutils.IntValue(newConfig.Space.Cache.Fetch.Expiration) ||
+			s.fetchCache.Cleanup != ttutils.IntValue(newConfig.Space.Cache.Fetch.Cleanup) ||
+			s.fetchCache.MaxEntries != ttutils.IntValue(newConfig.Space.Cache.Fetch.MaxEntries) ||
+			s.fetchCache.MaxBytes != ttutils.IntValue(newConfig.Space.Cache.Fetch.MaxBytes) {
+
+			s.fetchCache.Shutdown()
+			s.fetchCache = nil
+		} else {
+			s.fetchCache.Flush()
+		}
+	}
+
+	//
+	// cache stats
+	//
+	s.stopCacheStats()
+
+	//
+	// rate limiter
+	//
+	if s.rateLimiter != nil {
+		if reset, err := s.rateLimiter.Reset(newConfig); err != nil {
+			return s, err
+		} else {
+			s.rateLimiter = reset
+		}
+	}
+
 	//
 	// cron
 	//
@@ -178,6 +361,14 @@ func (s *Space) Reset(newConfig *ttutils.ConfigRoot) (*Space, error) {
 		s.cron = nil
 	}
 
+	//
+	// warm-up
+	//
+	if s.warmup != nil {
+		s.warmup.Shutdown()
+		s.warmup = nil
+	}
+
 	s.logger.
 		Infof("reloading... done!")
 
@@ -190,6 +381,13 @@ func (s *Space) Start() error {
 
 	s.isServing.SetTrue()
 
+	if warmup := s.getWarmup(); warmup != nil && ttutils.BoolValue(s.config.Space.Cache.Warmup.Wait) {
+		s.logger.
+			Infof("waiting for the warm-up...")
+
+		warmup.Wait()
+	}
+
 	if err := s.tcpListener.Serve(); err != nil {
 		return err
 	}
@@ -201,11 +399,29 @@ func (s *Space) Start() error {
 }
 
 func (s *Space) Shutdown() error {
+	s.logger.
+		Infof("stopping...")
+
+	//
+	// listener
+	//
+	// first and without the lock: the connections being drained still use the cache
+	// and the rate limiter
+	s.mu.RLock()
+	tcpListener := s.tcpListener
+	s.mu.RUnlock()
+
+	if tcpListener != nil {
+		tcpListener.Shutdown()
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.logger.
-		Infof("stopping...")
+	if s.tcpListener != nil {
+		s.tcpListener = nil
+		s.isServing.SetFalse()
+	}
 
 	// cache
 	if s.cache != nil {
@@ -213,6 +429,13 @@ func (s *Space) Shutdown() error {
 		s.cache = nil
 	}
 
+	if s.fetchCache != nil {
+		s.fetchCache.Shutdown()
+		s.fetchCache = nil
+	}
+
+	s.stopCacheStats()
+
 	//
 	// cron
 	//
@@ -222,12 +445,27 @@ func (s *Space) Shutdown() error {
 	}
 
 	//
-	// listener
+	// warm-up
 	//
-	if s.tcpListener != nil {
-		s.tcpListener.Shutdown()
-		s.tcpListener = nil
-		s.isServing.SetFalse()
+	if s.warmup != nil {
+		s.warmup.Shutdown()
+		s.warmup = nil
+	}
+
+	//
+	// rate limiter
+	//
+	if s.rateLimiter != nil {
+		s.rateLimiter.Shutdown()
+		s.rateLimiter = nil
+	}
+
+	//
+	// geoip
+	//
+	if s.geoIP != nil {
+		s.geoIP.Shutdown()
+		s.geoIP = nil
 	}
 
 	s.logger.
@@ -236,6 +474,187 @@ func (s *Space) Shutdown() error {
 	return nil
 }
 
+// watchRoutesCache reports the evictions of the routes cache of the configuration.
+func (s *Space) watchRoutesCache(config *ttutils.ConfigRoot) {
+	if config.Space.RoutesCache == nil {
+		return
+	}
+
+	config.Space.RoutesCache.SetOnEvict(func(evicted int) {
+		if err := s.prometheusFire(&ttprom.PrometheusMetric{
+			Metric: ttprom.PrometheusCacheEvictionsCounter,
+			Labels: []string{"routes"},
+			Action: "add",
+			Values: float64(evicted),
+		}); err != nil {
+			s.logger.
+				Errorf("firing prometheus failed -> %s", err)
+		}
+	})
+}
+
+// startCacheStats updates the Prometheus gauges of the cache stores at each interval, the lock must be held.
+func (s *Space) startCacheStats(interval int) {
+	ctx, ctxCancel := context.WithCancel(s.context)
+	s.statsCancel = ctxCancel
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.fireCacheStats()
+			}
+		}
+	}()
+}
+
+// stopCacheStats must be called with the lock held.
+func (s *Space) stopCacheStats() {
+	if s.statsCancel != nil {
+		s.statsCancel()
+		s.statsCancel = nil
+	}
+}
+
+// fireCacheStats sets the gauges and adds to the counters what has been counted since the previous export,
+// the evictions are counted as they happen (see ttprom.PrometheusCacheEvictionsCounter).
+func (s *Space) fireCacheStats() {
+	s.muStats.Lock()
+	defer s.muStats.Unlock()
+
+	if s.statsLast == nil {
+		s.statsLast = make(map[string]ttcache.Stats)
+	}
+
+	for _, stats := range s.CacheStats() {
+		last := s.statsLast[stats.Store]
+		s.statsLast[stats.Store] = stats
+
+		metrics := []*ttprom.PrometheusMetric{
+			{Metric: ttprom.PrometheusCacheEntriesGauge, Action: "set", Values: float64(stats.Entries)},
+			{Metric: ttprom.PrometheusCacheBytesGauge, Action: "set", Values: float64(stats.Bytes)},
+			{Metric: ttprom.PrometheusCacheHitsCounter, Action: "add", Values: statsDelta(stats.Hits, last.Hits)},
+			{Metric: ttprom.PrometheusCacheMissesCounter, Action: "add", Values: statsDelta(stats.Misses, last.Misses)},
+			{Metric: ttprom.PrometheusCacheExpirationsCounter, Action: "add", Values: statsDelta(stats.Expirations, last.Expirations)},
+		}
+
+		for _, metric := range metrics {
+			metric.Labels = []string{stats.Store}
+
+			if err := s.prometheusFire(metric); err != nil {
+				s.logger.
+					Errorf("firing prometheus failed -> %s", err)
+			}
+		}
+	}
+}
+
+// statsDelta returns what has been counted since the previous export,
+// a store counting from 0 again (a new store) has counted all of it.
+func statsDelta(current uint64, last uint64) float64 {
+	if current < last {
+		return float64(current)
+	}
+
+	return float64(current - last)
+}
+
+// CacheStats returns the statistics of the pages store and of the fetch cache.
+func (s *Space) CacheStats() []ttcache.Stats {
+	var stats []ttcache.Stats
+
+	if cache := s.GetCache(); cache != nil {
+		stats = append(stats, cache.Stats())
+	}
+
+	if fetchCache := s.GetFetchCache(); fetchCache != nil {
+		stats = append(stats, fetchCache.Stats())
+	}
+
+	return stats
+}
+
+// InvalidateCache deletes the pages of the routes matching the pattern (see ttutils.RouteMatcher)
+// and returns their number. With render, the routes are rendered again right away, as the cron does:
+// the ones found in the cache and the configured ones.
+func (s *Space) InvalidateCache(pattern string, render bool) (deleted int, routes []string, err error) {
+	match, err := ttutils.RouteMatcher(pattern)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	s.mu.RLock()
+	cache := s.cache
+	config := s.config
+	s.mu.RUnlock()
+
+	matched := make(map[string]bool)
+
+	if cache != nil {
+		deleted = cache.DeleteFunc(func(key string) bool {
+			route := tthandler.CacheKeyRoute(key)
+			if !match(route) {
+				return false
+			}
+
+			matched[route] = true
+
+			return true
+		})
+	}
+
+	s.logger.
+		Infof("%d items of the routes %s deleted from the cache", deleted, pattern)
+
+	if !render {
+		return deleted, nil, nil
+	}
+
+	for routeName := range config.Space.Routes {
+		if routeName[0] != '~' && match(routeName) {
+			matched[routeName] = true
+		}
+	}
+
+	for route := range matched {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+
+	renderer := ttwarmup.NewWarmup(&ttwarmup.ConfigInput{
+		Config:           config,
+		Cache:            s.GetCache,
+		FetchCache:       s.GetFetchCache,
+		Logger:           s.logger.WithField("space-svc", "invalidate"),
+		ServeConnHandler: s.serveConnHandler,
+		PrometheusFire:   s.prometheusFire,
+	})
+
+	renderer.Render(context.Background(), routes, 1)
+
+	return deleted, routes, nil
+}
+
+// Warmup starts the warm-up again, after a flush of the cache.
+func (s *Space) Warmup() {
+	if warmup := s.getWarmup(); warmup != nil {
+		warmup.Run()
+	}
+}
+
+func (s *Space) getWarmup() *ttwarmup.Warmup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.warmup
+}
+
 func (s *Space) GetCache() ttcache.ICacheCache {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -243,6 +662,27 @@ func (s *Space) GetCache() ttcache.ICacheCache {
 	return s.cache
 }
 
+func (s *Space) GetFetchCache() *ttcache.Fetch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.fetchCache
+}
+
+func (s *Space) GetRateLimiter() *ttratelimit.RateLimiter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rateLimiter
+}
+
+func (s *Space) GetGeoIP() *ttgeoip.GeoIP {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.geoIP
+}
+
 func (s *Space) IsServing() bool {
 	return s.isServing.IsSet()
 }
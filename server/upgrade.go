@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+// upgradeReadyEnv is the file descriptor used by the new process to tell
+// the previous one that it's serving.
+const upgradeReadyEnv = "TTSERVER_UPGRADE_READY_FD"
+
+var upgradeReadyTimeout = 60 * time.Second
+
+// upgrade starts a new process of the binary with the listeners of this one
+// and waits for it to be serving: this process can then drain its connections and exit.
+func (m *Manager) upgrade() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to find the binary: %s", err)
+	}
+
+	addresses, files, err := ttutils.ListenerFiles()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("unable to create the upgrade pipe: %s", err)
+	}
+	defer readyReader.Close()
+
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, ttutils.InheritedListenersEnv+"=") && !strings.HasPrefix(e, upgradeReadyEnv+"=") {
+			env = append(env, e)
+		}
+	}
+
+	// the extra files start at the file descriptor 3
+	env = append(env,
+		ttutils.InheritedListenersEnv+"="+strings.Join(addresses, ","),
+		upgradeReadyEnv+"="+strconv.Itoa(3+len(files)),
+	)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter)
+
+	err = cmd.Start()
+	readyWriter.Close()
+
+	// the files have been set to the blocking mode to be inherited
+	if errRestore := ttutils.RestoreListeners(); errRestore != nil {
+		m.logger.
+			WithField("svc", "manager").
+			Error(errRestore)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to start the new process: %s", err)
+	}
+
+	m.logger.
+		WithField("svc", "manager").
+		Infof("upgrading... waiting for the new process (%d)...", cmd.Process.Pid)
+
+	// the pipe is closed without a byte if the new process exits
+	readyChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyReader.Read(buf); err != nil {
+			readyChan <- errors.New("the new process exited before serving")
+			return
+		}
+
+		readyChan <- nil
+	}()
+
+	select {
+	case err = <-readyChan:
+	case <-time.After(upgradeReadyTimeout):
+		err = fmt.Errorf("the new process was not serving after %s", upgradeReadyTimeout)
+		cmd.Process.Kill()
+	}
+
+	if err != nil {
+		go cmd.Wait()
+		return err
+	}
+
+	return nil
+}
+
+// notifyUpgradeReady tells the previous process, if any, that this one is serving.
+func notifyUpgradeReady() error {
+	env := os.Getenv(upgradeReadyEnv)
+	if env == "" {
+		return nil
+	}
+
+	os.Unsetenv(upgradeReadyEnv)
+	os.Unsetenv(ttutils.InheritedListenersEnv)
+
+	fd, err := strconv.Atoi(env)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", upgradeReadyEnv, env)
+	}
+
+	f := os.NewFile(uintptr(fd), "upgrade")
+	defer f.Close()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		return fmt.Errorf("unable to notify the previous process: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package acmedns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+)
+
+type (
+	// Parameters are the settings of a DNS provider.
+	// A value can be given directly, read from a file (with the "_file" suffix)
+	// or read from an environment variable.
+	Parameters map[string]string
+
+	// Factory creates a DNS provider from its parameters.
+	Factory func(params Parameters) (certmagic.ACMEDNSProvider, error)
+)
+
+var (
+	providers = map[string]Factory{
+		"gandi":        newGandi,
+		"cloudflare":   newCloudflare,
+		"hetzner":      newHetzner,
+		"digitalocean": newDigitalOcean,
+		"rfc2136":      newRFC2136,
+		"exec":         newExec,
+	}
+
+	muProviders sync.RWMutex
+
+	defaultTTL = 120 * time.Second
+)
+
+// Register adds a DNS provider to the registry.
+func Register(name string, factory Factory) {
+	muProviders.Lock()
+	defer muProviders.Unlock()
+
+	providers[name] = factory
+}
+
+func IsRegistered(name string) bool {
+	muProviders.RLock()
+	defer muProviders.RUnlock()
+
+	_, ok := providers[name]
+
+	return ok
+}
+
+func Names() []string {
+	muProviders.RLock()
+	defer muProviders.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func New(name string, params Parameters) (certmagic.ACMEDNSProvider, error) {
+	muProviders.RLock()
+	factory, ok := providers[name]
+	muProviders.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown dns-provider %s (available: %s)", name, strings.Join(Names(), ", "))
+	}
+
+	provider, err := factory(params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure dns-provider %s: %s", name, err)
+	}
+
+	return provider, nil
+}
+
+// Get returns the value of a parameter, looking in order at:
+// the parameter itself, the file pointed by the parameter "<key>_file"
+// and the environment variable env (if any).
+func (p Parameters) Get(key string, env string) (string, error) {
+	if v, ok := p[key]; ok && v != "" {
+		return v, nil
+	}
+
+	if file, ok := p[key+"_file"]; ok && file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s_file: %s", key, err)
+		}
+
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	if env != "" {
+		return os.Getenv(env), nil
+	}
+
+	return "", nil
+}
+
+// Required is like Get but fails if no value is found.
+func (p Parameters) Required(key string, env string) (string, error) {
+	v, err := p.Get(key, env)
+	if err != nil {
+		return "", err
+	}
+
+	if v == "" {
+		if env != "" {
+			return "", fmt.Errorf("no %s configured (or %s_file or the %s environment variable)", key, key, env)
+		}
+
+		return "", fmt.Errorf("no %s configured (or %s_file)", key, key)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,68 @@
+package acmedns
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+)
+
+type (
+	// ExecProvider calls an external program to update the records:
+	//   <command> present <fqdn> <value> <ttl>
+	//   <command> cleanup <fqdn> <value>
+	ExecProvider struct {
+		Command string
+	}
+)
+
+func newExec(params Parameters) (certmagic.ACMEDNSProvider, error) {
+	command, err := params.Required("command", "EXEC_PATH")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, fmt.Errorf("unable to find command %s: %s", command, err)
+	}
+
+	return &ExecProvider{Command: command}, nil
+}
+
+func (p *ExecProvider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		ttl := rec.TTL
+		if ttl <= 0 {
+			ttl = defaultTTL
+		}
+
+		if err := p.run(ctx, "present", recordFQDN(rec.Name, zone), rec.Value, strconv.Itoa(int(ttl.Seconds()))); err != nil {
+			return nil, err
+		}
+	}
+
+	return recs, nil
+}
+
+func (p *ExecProvider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		if err := p.run(ctx, "cleanup", recordFQDN(rec.Name, zone), rec.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return recs, nil
+}
+
+func (p *ExecProvider) run(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, p.Command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s (%s)", p.Command, args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package acmedns
+
+import (
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/cloudflare"
+	"github.com/libdns/digitalocean"
+	"github.com/libdns/gandi"
+	"github.com/libdns/hetzner"
+)
+
+func newGandi(params Parameters) (certmagic.ACMEDNSProvider, error) {
+	token, err := params.Required("api_token", "GANDI_API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+
+	return &gandi.Provider{APIToken: token}, nil
+}
+
+func newCloudflare(params Parameters) (certmagic.ACMEDNSProvider, error) {
+	token, err := params.Required("api_token", "CLOUDFLARE_API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudflare.Provider{APIToken: token}, nil
+}
+
+func newHetzner(params Parameters) (certmagic.ACMEDNSProvider, error) {
+	token, err := params.Required("api_token", "HETZNER_API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+
+	return &hetzner.Provider{AuthAPIToken: token}, nil
+}
+
+func newDigitalOcean(params Parameters) (certmagic.ACMEDNSProvider, error) {
+	token, err := params.Required("api_token", "DO_API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+
+	return &digitalocean.Provider{APIToken: token}, nil
+}
@@ -0,0 +1,155 @@
+package acmedns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+type (
+	// RFC2136Provider updates the records with RFC 2136 dynamic updates,
+	// optionally signed with a TSIG key.
+	RFC2136Provider struct {
+		Nameserver    string
+		Network       string
+		TSIGKey       string
+		TSIGSecret    string
+		TSIGAlgorithm string
+	}
+)
+
+func newRFC2136(params Parameters) (certmagic.ACMEDNSProvider, error) {
+	var err error
+
+	p := &RFC2136Provider{}
+
+	if p.Nameserver, err = params.Required("nameserver", "RFC2136_NAMESERVER"); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := net.SplitHostPort(p.Nameserver); err != nil {
+		p.Nameserver = net.JoinHostPort(p.Nameserver, "53")
+	}
+
+	if p.Network, err = params.Get("network", ""); err != nil {
+		return nil, err
+	}
+
+	if p.Network != "" && p.Network != "udp" && p.Network != "tcp" {
+		return nil, fmt.Errorf("unknown network %s", p.Network)
+	}
+
+	if p.TSIGKey, err = params.Get("tsig_key", "RFC2136_TSIG_KEY"); err != nil {
+		return nil, err
+	}
+
+	if p.TSIGKey != "" {
+		if p.TSIGSecret, err = params.Required("tsig_secret", "RFC2136_TSIG_SECRET"); err != nil {
+			return nil, err
+		}
+
+		if p.TSIGAlgorithm, err = params.Get("tsig_algorithm", "RFC2136_TSIG_ALGORITHM"); err != nil {
+			return nil, err
+		}
+
+		if p.TSIGAlgorithm == "" {
+			p.TSIGAlgorithm = dns.HmacSHA256
+		}
+
+		p.TSIGKey = dns.Fqdn(p.TSIGKey)
+		p.TSIGAlgorithm = dns.Fqdn(strings.ToLower(p.TSIGAlgorithm))
+	}
+
+	return p, nil
+}
+
+func (p *RFC2136Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	if err := p.update(ctx, zone, recs, true); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+func (p *RFC2136Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	if err := p.update(ctx, zone, recs, false); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+func (p *RFC2136Provider) update(ctx context.Context, zone string, recs []libdns.Record, insert bool) error {
+	zone = dns.Fqdn(zone)
+
+	rrs := make([]dns.RR, 0, len(recs))
+
+	for _, rec := range recs {
+		if rec.Type != "TXT" {
+			return fmt.Errorf("unsupported record type %s", rec.Type)
+		}
+
+		ttl := rec.TTL
+		if ttl <= 0 {
+			ttl = defaultTTL
+		}
+
+		rrs = append(rrs, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   recordFQDN(rec.Name, zone),
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    uint32(ttl.Seconds()),
+			},
+			Txt: []string{rec.Value},
+		})
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+
+	if insert {
+		m.Insert(rrs)
+	} else {
+		m.Remove(rrs)
+	}
+
+	c := &dns.Client{Net: p.Network}
+
+	if p.TSIGKey != "" {
+		m.SetTsig(p.TSIGKey, p.TSIGAlgorithm, 300, time.Now().Unix())
+		c.TsigSecret = map[string]string{p.TSIGKey: p.TSIGSecret}
+	}
+
+	r, _, err := c.ExchangeContext(ctx, m, p.Nameserver)
+	if err != nil {
+		return fmt.Errorf("dynamic update of zone %s failed: %s", zone, err)
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("dynamic update of zone %s refused: %s", zone, dns.RcodeToString[r.Rcode])
+	}
+
+	return nil
+}
+
+// recordFQDN returns the fully qualified name of a record which can be
+// relative to the zone or already fully qualified.
+func recordFQDN(name string, zone string) string {
+	fqdn := dns.Fqdn(name)
+	if fqdn == zone || strings.HasSuffix(fqdn, "."+zone) {
+		return fqdn
+	}
+
+	if name == "" || name == "@" {
+		return zone
+	}
+
+	return name + "." + zone
+}
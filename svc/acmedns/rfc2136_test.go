@@ -0,0 +1,256 @@
+package acmedns
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+const testTSIGSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+
+// startTestNameserver answers the updates with rcode and sends them to the channel.
+func startTestNameserver(t *testing.T, rcode int) (string, <-chan *dns.Msg) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+
+	updates := make(chan *dns.Msg, 10)
+	started := make(chan struct{})
+
+	server := &dns.Server{
+		PacketConn:        pc,
+		TsigSecret:        map[string]string{"acme.": testTSIGSecret},
+		NotifyStartedFunc: func() { close(started) },
+		// the updates are refused by default
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetRcode(r, rcode)
+
+			if r.IsTsig() != nil {
+				if w.TsigStatus() != nil {
+					m.SetRcode(r, dns.RcodeNotAuth)
+				}
+
+				m.SetTsig(r.IsTsig().Hdr.Name, r.IsTsig().Algorithm, 300, time.Now().Unix())
+			}
+
+			updates <- r
+
+			_ = w.WriteMsg(m)
+		}),
+	}
+
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	<-started
+
+	return pc.LocalAddr().String(), updates
+}
+
+func TestRFC2136Parameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Parameters
+		wanted *RFC2136Provider
+		err    string
+	}{
+		{"no nameserver", Parameters{}, nil, "no nameserver"},
+		{
+			"default port", Parameters{"nameserver": "192.0.2.1"},
+			&RFC2136Provider{Nameserver: "192.0.2.1:53"}, "",
+		},
+		{
+			"tsig", Parameters{"nameserver": "[2001:db8::1]:5353", "network": "tcp", "tsig_key": "acme", "tsig_secret": "secret"},
+			&RFC2136Provider{Nameserver: "[2001:db8::1]:5353", Network: "tcp", TSIGKey: "acme.", TSIGSecret: "secret", TSIGAlgorithm: dns.HmacSHA256}, "",
+		},
+		{
+			"tsig algorithm", Parameters{"nameserver": "192.0.2.1:53", "tsig_key": "acme.", "tsig_secret": "secret", "tsig_algorithm": "HMAC-SHA512"},
+			&RFC2136Provider{Nameserver: "192.0.2.1:53", TSIGKey: "acme.", TSIGSecret: "secret", TSIGAlgorithm: dns.HmacSHA512}, "",
+		},
+		{"tsig without secret", Parameters{"nameserver": "192.0.2.1", "tsig_key": "acme"}, nil, "no tsig_secret"},
+		{"unknown network", Parameters{"nameserver": "192.0.2.1", "network": "sctp"}, nil, "unknown network"},
+	}
+
+	for _, env := range []string{"RFC2136_NAMESERVER", "RFC2136_TSIG_KEY", "RFC2136_TSIG_SECRET", "RFC2136_TSIG_ALGORITHM"} {
+		if os.Getenv(env) != "" {
+			t.Skipf("%s is set", env)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := newRFC2136(tt.params)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, wanted %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unable to configure: %s", err)
+			}
+
+			if got := provider.(*RFC2136Provider); *got != *tt.wanted {
+				t.Errorf("provider = %+v, wanted %+v", got, tt.wanted)
+			}
+		})
+	}
+}
+
+func TestRecordFQDN(t *testing.T) {
+	tests := []struct {
+		name   string
+		wanted string
+	}{
+		{"_acme-challenge", "_acme-challenge.example.org."},
+		{"_acme-challenge.www", "_acme-challenge.www.example.org."},
+		{"_acme-challenge.example.org", "_acme-challenge.example.org."},
+		{"_acme-challenge.example.org.", "_acme-challenge.example.org."},
+		{"@", "example.org."},
+		{"", "example.org."},
+	}
+
+	for _, tt := range tests {
+		if got := recordFQDN(tt.name, "example.org."); got != tt.wanted {
+			t.Errorf("recordFQDN(%q) = %q, wanted %q", tt.name, got, tt.wanted)
+		}
+	}
+}
+
+func TestRFC2136Update(t *testing.T) {
+	nameserver, updates := startTestNameserver(t, dns.RcodeSuccess)
+
+	provider, err := newRFC2136(Parameters{"nameserver": nameserver, "tsig_key": "acme", "tsig_secret": testTSIGSecret})
+	if err != nil {
+		t.Fatalf("unable to configure: %s", err)
+	}
+
+	recs := []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: "token", TTL: time.Minute}}
+
+	if _, err := provider.AppendRecords(context.Background(), "example.org", recs); err != nil {
+		t.Fatalf("unable to append: %s", err)
+	}
+
+	update := <-updates
+
+	if update.Opcode != dns.OpcodeUpdate || update.Question[0].Name != "example.org." || update.IsTsig() == nil {
+		t.Errorf("unexpected update: %s", update)
+	}
+
+	txt, ok := update.Ns[0].(*dns.TXT)
+	if !ok || txt.Hdr.Name != "_acme-challenge.example.org." || txt.Hdr.Ttl != 60 || txt.Txt[0] != "token" {
+		t.Errorf("unexpected record: %s", update.Ns[0])
+	}
+
+	if _, err := provider.DeleteRecords(context.Background(), "example.org", recs); err != nil {
+		t.Fatalf("unable to delete: %s", err)
+	}
+
+	// a removal has the class NONE
+	if update := <-updates; update.Ns[0].Header().Class != dns.ClassNONE {
+		t.Errorf("unexpected removal: %s", update.Ns[0])
+	}
+
+	if _, err := provider.AppendRecords(context.Background(), "example.org", []libdns.Record{{Type: "A", Name: "www"}}); err == nil {
+		t.Errorf("a record other than TXT is sent")
+	}
+}
+
+func TestRFC2136UpdateRefused(t *testing.T) {
+	recs := []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: "token"}}
+
+	tests := []struct {
+		name   string
+		rcode  int
+		secret string
+	}{
+		{"refused", dns.RcodeRefused, testTSIGSecret},
+		{"wrong secret", dns.RcodeSuccess, "d3Jvbmc="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameserver, _ := startTestNameserver(t, tt.rcode)
+
+			provider, err := newRFC2136(Parameters{"nameserver": nameserver, "tsig_key": "acme", "tsig_secret": tt.secret})
+			if err != nil {
+				t.Fatalf("unable to configure: %s", err)
+			}
+
+			if _, err := provider.AppendRecords(context.Background(), "example.org", recs); err == nil {
+				t.Errorf("the update succeeds")
+			}
+		})
+	}
+}
+
+// TestRFC2136Nameserver needs a nameserver accepting the dynamic updates (BIND, knot...),
+// it's configured with the environment variables of the provider and TTSERVER_TEST_RFC2136_ZONE:
+// a TXT record is added then removed.
+func TestRFC2136Nameserver(t *testing.T) {
+	zone := os.Getenv("TTSERVER_TEST_RFC2136_ZONE")
+	if zone == "" || os.Getenv("RFC2136_NAMESERVER") == "" {
+		t.Skip("TTSERVER_TEST_RFC2136_ZONE or RFC2136_NAMESERVER is not set")
+	}
+
+	provider, err := New("rfc2136", Parameters{})
+	if err != nil {
+		t.Fatalf("unable to configure: %s", err)
+	}
+
+	nameserver := provider.(*RFC2136Provider).Nameserver
+	name := "_ttserver-test-" + time.Now().Format("20060102150405")
+	fqdn := recordFQDN(name, dns.Fqdn(zone))
+
+	recs := []libdns.Record{{Type: "TXT", Name: name, Value: "token", TTL: time.Minute}}
+
+	lookup := func() bool {
+		m := new(dns.Msg)
+		m.SetQuestion(fqdn, dns.TypeTXT)
+
+		r, err := dns.Exchange(m, nameserver)
+		if err != nil {
+			t.Fatalf("unable to query %s: %s", fqdn, err)
+		}
+
+		for _, rr := range r.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && txt.Txt[0] == "token" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if _, err := provider.AppendRecords(context.Background(), zone, recs); err != nil {
+		t.Fatalf("unable to append: %s", err)
+	}
+
+	if !lookup() {
+		t.Errorf("the record %s isn't found", fqdn)
+	}
+
+	if _, err := provider.DeleteRecords(context.Background(), zone, recs); err != nil {
+		t.Fatalf("unable to delete: %s", err)
+	}
+
+	if lookup() {
+		t.Errorf("the record %s is still found", fqdn)
+	}
+}
@@ -1,6 +1,10 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
 	"time"
 )
 
@@ -8,6 +12,46 @@ type (
 	Item struct {
 		Item interface{}
 		Code string
+
+		// Fresh is the end of the freshness of the item, kept longer to be served stale (zero if always fresh)
+		Fresh time.Time
+	}
+
+	// storedItem is an Item serialized by the stores outside of the process.
+	storedItem struct {
+		Key        string
+		Data       []byte
+		Code       string
+		Fresh      int64
+		Expiration int64
+	}
+
+	// Stats are the statistics of a store since it was started.
+	Stats struct {
+		Store       string
+		Entries     int
+		Bytes       int
+		Hits        uint64
+		Misses      uint64
+		Evictions   uint64
+		Expirations uint64
+	}
+
+	// KeyInfo describes a stored item.
+	KeyInfo struct {
+		Key  string
+		Size int
+		TTL  time.Duration // the remaining time before the expiration, -1 means no expiration
+	}
+
+	// counters counts the lookups and the removals of a store.
+	counters struct {
+		hits        uint64
+		misses      uint64
+		evictions   uint64
+		expirations uint64
+
+		mu sync.Mutex
 	}
 
 	ICacheCache interface {
@@ -17,6 +61,15 @@ type (
 		Delete(key string)
 		Add(key string, value *Item, d time.Duration) error
 
+		// DeleteFunc deletes the items whose key matches and returns their number
+		DeleteFunc(match func(key string) bool) int
+
+		// Stats returns the statistics of the store
+		Stats() Stats
+
+		// Keys returns the items that haven't expired
+		Keys() []KeyInfo
+
 		IsEnabled() bool
 		IsDisabled() bool
 
@@ -25,3 +78,110 @@ type (
 		Shutdown()
 	}
 )
+
+// encodeItem serializes the item, only []byte can be stored outside of the process.
+func encodeItem(key string, value *Item, expiration int64) ([]byte, error) {
+	data, ok := value.Item.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unable to store the item %s: only []byte can be stored", key)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&storedItem{
+		Key:        key,
+		Data:       data,
+		Code:       value.Code,
+		Fresh:      unixNano(value.Fresh),
+		Expiration: expiration,
+	}); err != nil {
+		return nil, fmt.Errorf("unable to encode the item %s: %s", key, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeItem(data []byte) (*storedItem, error) {
+	var item storedItem
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// item returns the Item of a storedItem.
+func (s *storedItem) item() *Item {
+	item := Item{
+		Item: s.Data,
+		Code: s.Code,
+	}
+
+	if s.Fresh > 0 {
+		item.Fresh = time.Unix(0, s.Fresh)
+	}
+
+	return &item
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func (c *counters) lookup(found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if found {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+func (c *counters) evicted(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictions += uint64(n)
+}
+
+func (c *counters) expired(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expirations += uint64(n)
+}
+
+// stats returns the Stats of the store with the counters, the entries and the bytes.
+func (c *counters) stats(store string, entries int, bytes int) Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Store:       store,
+		Entries:     entries,
+		Bytes:       bytes,
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+	}
+}
+
+// ttl returns the remaining time before the expiration, a Unix time in nanoseconds (0 means no expiration).
+func ttl(expiration int64) time.Duration {
+	if expiration == 0 {
+		return -1
+	}
+
+	return time.Until(time.Unix(0, expiration))
+}
+
+// IsFresh tells if the item can be served without being rendered again.
+func (i *Item) IsFresh() bool {
+	return i.Fresh.IsZero() || time.Now().Before(i.Fresh)
+}
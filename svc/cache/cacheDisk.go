@@ -0,0 +1,436 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Disk stores each item in a file of a directory, the items are kept across the restarts.
+	Disk struct {
+		Path       string
+		Cleanup    int
+		Expiration int
+
+		counters counters
+
+		logger        *logrus.Entry
+		context       context.Context
+		contextCancel context.CancelFunc
+
+		mu            sync.RWMutex
+		isInitialized bool
+	}
+
+	DiskConfigInput struct {
+		Path       string
+		Expiration int
+		Cleanup    int
+		Logger     *logrus.Entry
+	}
+)
+
+const (
+	diskEntryExt     = ".cache"
+	diskEntryTmpGlob = ".tmp-*"
+)
+
+func NewCacheDisk(cacheConfig *DiskConfigInput) ICacheCache {
+	c := new(Disk)
+
+	c.Path = cacheConfig.Path
+	c.Expiration = cacheConfig.Expiration
+	c.Cleanup = cacheConfig.Cleanup
+
+	c.logger = cacheConfig.Logger
+
+	return c
+}
+
+func (c *Disk) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.
+		Infof("starting...")
+
+	if err := os.MkdirAll(c.Path, 0700); err != nil {
+		c.logger.
+			Errorf("unable to create %s, the cache is disabled -> %s", c.Path, err)
+
+		return
+	}
+
+	listenCtx, listenCancelCtx := context.WithCancel(context.Background())
+	c.context = listenCtx
+	c.contextCancel = listenCancelCtx
+
+	c.isInitialized = true
+
+	// the items expired while the server was stopped
+	c.compact()
+
+	if c.Cleanup > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(c.Cleanup) * time.Second)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-listenCtx.Done():
+					return
+				case <-ticker.C:
+					c.mu.Lock()
+					if c.isInitialized {
+						c.compact()
+					}
+					c.mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	c.logger.
+		Infof("starting... done!")
+}
+
+// compact removes the expired items and the files left by an interrupted write,
+// the lock must be held.
+func (c *Disk) compact() {
+	c.logger.
+		Debugf("compacting...")
+
+	removed := 0
+	expired := 0
+
+	files, err := filepath.Glob(filepath.Join(c.Path, "*"+diskEntryExt))
+	if err != nil {
+		c.logger.
+			Errorf("unable to list %s -> %s", c.Path, err)
+
+		return
+	}
+
+	now := time.Now().UnixNano()
+
+	for _, file := range files {
+		entry, err := readDiskEntry(file)
+		if err != nil || (entry.Expiration > 0 && now > entry.Expiration) {
+			os.Remove(file)
+			removed++
+
+			if err == nil {
+				expired++
+			}
+		}
+	}
+
+	c.counters.expired(expired)
+
+	tmpFiles, _ := filepath.Glob(filepath.Join(c.Path, diskEntryTmpGlob))
+	for _, file := range tmpFiles {
+		os.Remove(file)
+	}
+
+	c.logger.
+		Debugf("compacting... done! (%d items removed)", removed)
+}
+
+func (c *Disk) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.
+		Debugf("flushing...")
+
+	if c.isInitialized {
+		files, err := filepath.Glob(filepath.Join(c.Path, "*"+diskEntryExt))
+		if err != nil {
+			c.logger.
+				Errorf("unable to list %s -> %s", c.Path, err)
+		}
+
+		for _, file := range files {
+			if err := os.Remove(file); err != nil {
+				c.logger.
+					Errorf("unable to remove %s -> %s", file, err)
+			}
+		}
+	}
+
+	c.logger.
+		Debugf("flushing... done!")
+}
+
+// Shutdown stops the cache, the items are kept on the disk.
+func (c *Disk) Shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.
+		Infof("stopping...")
+
+	if c.isInitialized {
+		c.contextCancel()
+		c.isInitialized = false
+	}
+
+	c.logger.
+		Infof("stopping... done!")
+}
+
+func (c *Disk) Get(key string) (*Item, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, false
+	}
+
+	entry, ok := c.get(key)
+	c.counters.lookup(ok)
+
+	if !ok {
+		return nil, false
+	}
+
+	return entry.item(), true
+}
+
+// get returns the item if it hasn't expired, the lock must be held.
+func (c *Disk) get(key string) (*storedItem, bool) {
+	entry, err := readDiskEntry(c.file(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.
+				Errorf("unable to read the item %s -> %s", key, err)
+		}
+
+		return nil, false
+	}
+
+	if entry.Key != key || (entry.Expiration > 0 && time.Now().UnixNano() > entry.Expiration) {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (c *Disk) Replace(key string, value *Item, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d >= 0 && c.isInitialized {
+		if _, ok := c.get(key); !ok {
+			return fmt.Errorf("item %s doesn't exist", key)
+		}
+
+		return c.set(key, value, d)
+	}
+
+	return nil
+}
+
+func (c *Disk) ReplaceIfExists(key string, value *Item, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d >= 0 && c.isInitialized {
+		return c.set(key, value, d)
+	}
+
+	return nil
+}
+
+func (c *Disk) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isInitialized {
+		if err := os.Remove(c.file(key)); err != nil && !os.IsNotExist(err) {
+			c.logger.
+				Errorf("unable to delete the item %s -> %s", key, err)
+		}
+	}
+}
+
+func (c *Disk) DeleteFunc(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+
+	if c.isInitialized {
+		files, err := filepath.Glob(filepath.Join(c.Path, "*"+diskEntryExt))
+		if err != nil {
+			c.logger.
+				Errorf("unable to list %s -> %s", c.Path, err)
+		}
+
+		for _, file := range files {
+			entry, err := readDiskEntry(file)
+			if err != nil || !match(entry.Key) {
+				continue
+			}
+
+			if err := os.Remove(file); err != nil {
+				c.logger.
+					Errorf("unable to delete the item %s -> %s", entry.Key, err)
+
+				continue
+			}
+
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+func (c *Disk) Add(key string, value *Item, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d >= 0 && c.isInitialized {
+		if _, ok := c.get(key); ok {
+			return fmt.Errorf("item %s already exists", key)
+		}
+
+		return c.set(key, value, d)
+	}
+
+	return nil
+}
+
+// set writes the item in a temporary file renamed once complete, the lock must be held.
+func (c *Disk) set(key string, value *Item, d time.Duration) error {
+	var expiration int64
+	if d > 0 {
+		expiration = time.Now().Add(d).UnixNano()
+	}
+
+	data, err := encodeItem(key, value, expiration)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(c.Path, strings.TrimSuffix(diskEntryTmpGlob, "*"))
+	if err != nil {
+		return fmt.Errorf("unable to store the item %s: %s", key, err)
+	}
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("unable to store the item %s: %s", key, err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("unable to store the item %s: %s", key, err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), c.file(key)); err != nil {
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("unable to store the item %s: %s", key, err)
+	}
+
+	return nil
+}
+
+func (c *Disk) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.Path, hex.EncodeToString(sum[:])+diskEntryExt)
+}
+
+func (c *Disk) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var entries, bytes int
+
+	if c.isInitialized {
+		files, err := filepath.Glob(filepath.Join(c.Path, "*"+diskEntryExt))
+		if err != nil {
+			c.logger.
+				Errorf("unable to list %s -> %s", c.Path, err)
+		}
+
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				entries++
+				bytes += int(info.Size())
+			}
+		}
+	}
+
+	return c.counters.stats("disk", entries, bytes)
+}
+
+// Keys reads all the items of the directory.
+func (c *Disk) Keys() []KeyInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var keys []KeyInfo
+
+	if c.isInitialized {
+		files, err := filepath.Glob(filepath.Join(c.Path, "*"+diskEntryExt))
+		if err != nil {
+			c.logger.
+				Errorf("unable to list %s -> %s", c.Path, err)
+		}
+
+		now := time.Now().UnixNano()
+
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+
+			entry, err := decodeItem(data)
+			if err != nil || (entry.Expiration > 0 && now > entry.Expiration) {
+				continue
+			}
+
+			keys = append(keys, KeyInfo{Key: entry.Key, Size: len(data), TTL: ttl(entry.Expiration)})
+		}
+	}
+
+	return keys
+}
+
+func (c *Disk) IsEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.isInitialized && c.Expiration > -1
+}
+
+func (c *Disk) IsDisabled() bool {
+	return !c.IsEnabled()
+}
+
+func readDiskEntry(file string) (*storedItem, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeItem(data)
+}
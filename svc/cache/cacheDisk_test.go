@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCacheDisk(t *testing.T, path string) ICacheCache {
+	c := NewCacheDisk(&DiskConfigInput{Path: path, Logger: testLogger()})
+	c.Start()
+
+	if c.IsDisabled() {
+		t.Fatalf("the cache is disabled")
+	}
+
+	return c
+}
+
+func TestCacheDisk(t *testing.T) {
+	c := newTestCacheDisk(t, t.TempDir())
+	defer c.Shutdown()
+
+	testStore(t, c)
+}
+
+func TestCacheDiskExpiration(t *testing.T) {
+	testExpiration(t, func(expiration int) ICacheCache {
+		c := NewCacheDisk(&DiskConfigInput{Expiration: expiration, Path: t.TempDir(), Logger: testLogger()})
+		c.Start()
+
+		return c
+	})
+}
+
+func TestCacheDiskRestart(t *testing.T) {
+	path := t.TempDir()
+
+	c := newTestCacheDisk(t, path)
+
+	if err := c.ReplaceIfExists("index", &Item{Item: []byte("hello")}, 0); err != nil {
+		t.Fatalf("unable to store: %s", err)
+	}
+
+	if err := c.ReplaceIfExists("expired", &Item{Item: []byte("hello")}, time.Nanosecond); err != nil {
+		t.Fatalf("unable to store: %s", err)
+	}
+
+	// left by an interrupted write
+	if err := ioutil.WriteFile(filepath.Join(path, ".tmp-1234"), []byte("partial"), 0600); err != nil {
+		t.Fatalf("unable to write: %s", err)
+	}
+
+	c.Shutdown()
+
+	c = newTestCacheDisk(t, path)
+	defer c.Shutdown()
+
+	if _, ok := c.Get("index"); !ok {
+		t.Errorf("the item isn't kept across the restarts")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(path, "*"))
+	if len(files) != 1 {
+		t.Errorf("the expired item and the temporary file aren't removed: %v", files)
+	}
+}
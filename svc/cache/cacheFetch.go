@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+type (
+	// Fetch stores the results of the fetches of the routes, shared between the routes by type and URI.
+	// The results are parsed and can't be serialized: they are always kept in memory, apart from the pages.
+	Fetch struct {
+		Expiration int
+		Cleanup    int
+		MaxEntries int
+		MaxBytes   int
+
+		cache          *ttutils.LRU
+		counters       counters
+		prometheusFire func(*ttprom.PrometheusMetric) error
+
+		logger        *logrus.Entry
+		context       context.Context
+		contextCancel context.CancelFunc
+
+		mu            sync.RWMutex
+		isInitialized bool
+	}
+
+	// FetchItem is the result of a fetch. It is kept once expired while it has validators,
+	// to be fetched again with a conditional GET.
+	FetchItem struct {
+		Type         string
+		URI          string
+		Data         interface{}
+		Size         int
+		ETag         string
+		LastModified string
+		Fetched      time.Time // the last fetch or revalidation
+		Fresh        time.Time // the end of the TTL, zero means unlimited
+	}
+
+	FetchConfigInput struct {
+		Expiration     int
+		Cleanup        int
+		MaxEntries     int
+		MaxBytes       int
+		PrometheusFire func(*ttprom.PrometheusMetric) error
+		Logger         *logrus.Entry
+	}
+)
+
+func NewCacheFetch(cacheConfig *FetchConfigInput) *Fetch {
+	c := new(Fetch)
+
+	c.cache = ttutils.NewLRU(cacheConfig.MaxEntries, cacheConfig.MaxBytes)
+
+	c.Expiration = cacheConfig.Expiration
+	c.Cleanup = cacheConfig.Cleanup
+	c.MaxEntries = cacheConfig.MaxEntries
+	c.MaxBytes = cacheConfig.MaxBytes
+
+	c.prometheusFire = cacheConfig.PrometheusFire
+	c.logger = cacheConfig.Logger
+
+	c.cache.SetOnEvict(c.fireEvictionsMetric)
+
+	return c
+}
+
+func (c *Fetch) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.
+		Infof("starting...")
+
+	listenCtx, listenCancelCtx := context.WithCancel(context.Background())
+	c.context = listenCtx
+	c.contextCancel = listenCancelCtx
+
+	c.isInitialized = true
+
+	if c.Cleanup > 0 {
+		lru := c.cache
+
+		go func() {
+			ticker := time.NewTicker(time.Duration(c.Cleanup) * time.Second)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-listenCtx.Done():
+					return
+				case <-ticker.C:
+					c.logger.
+						Tracef("%d expired items removed", lru.DeleteExpired())
+				}
+			}
+		}()
+	}
+
+	c.logger.
+		Infof("starting... done!")
+}
+
+func (c *Fetch) fireEvictionsMetric(evicted int) {
+	c.logger.
+		Debugf("%d items evicted", evicted)
+
+	c.counters.evicted(evicted)
+
+	if err := c.prometheusFire(&ttprom.PrometheusMetric{
+		Metric: ttprom.PrometheusCacheEvictionsCounter,
+		Labels: []string{"fetch"},
+		Action: "add",
+		Values: float64(evicted),
+	}); err != nil {
+		c.logger.
+			Errorf("firing prometheus failed -> %s", err)
+	}
+}
+
+func (c *Fetch) Flush() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.logger.
+		Debugf("flushing...")
+
+	if c.isInitialized {
+		c.cache.Flush()
+	}
+
+	c.logger.
+		Debugf("flushing... done!")
+}
+
+func (c *Fetch) Shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.
+		Infof("stopping...")
+
+	if c.isInitialized {
+		c.contextCancel()
+		c.cache.Flush()
+		c.isInitialized = false
+	}
+
+	c.logger.
+		Infof("stopping... done!")
+}
+
+// Get returns the result of the fetch, even if it has expired.
+func (c *Fetch) Get(fetchType string, uri string) (*FetchItem, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, false
+	}
+
+	value, ok := c.cache.Get(fetchKey(fetchType, uri))
+	c.counters.lookup(ok)
+
+	if !ok {
+		return nil, false
+	}
+
+	return value.(*FetchItem), true
+}
+
+// Set stores the result of the fetch, it is removed once expired if it can't be revalidated.
+func (c *Fetch) Set(item *FetchItem) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return
+	}
+
+	var expiration int64
+	if !item.Fresh.IsZero() && item.ETag == "" && item.LastModified == "" {
+		expiration = item.Fresh.UnixNano()
+	}
+
+	if !c.cache.Set(fetchKey(item.Type, item.URI), item, item.Size, expiration) {
+		c.logger.
+			Debugf("%s is too large for the cache (%d bytes)", item.URI, item.Size)
+	}
+}
+
+func (c *Fetch) Delete(fetchType string, uri string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.isInitialized {
+		c.cache.Delete(fetchKey(fetchType, uri))
+	}
+}
+
+// Items returns the stored results, the most recently used first.
+func (c *Fetch) Items() []*FetchItem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var items []*FetchItem
+
+	if c.isInitialized {
+		c.cache.Range(func(key string, value interface{}, size int, expiration int64) bool {
+			items = append(items, value.(*FetchItem))
+			return true
+		})
+	}
+
+	return items
+}
+
+func (c *Fetch) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return c.counters.stats("fetch", 0, 0)
+	}
+
+	stats := c.counters.stats("fetch", c.cache.Len(), c.cache.Bytes())
+	stats.Expirations = uint64(c.cache.Expired())
+
+	return stats
+}
+
+func (c *Fetch) IsEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.isInitialized && c.Expiration > -1
+}
+
+func (c *Fetch) IsDisabled() bool {
+	return !c.IsEnabled()
+}
+
+// IsFresh tells if the result can be used without fetching it again.
+func (i *FetchItem) IsFresh() bool {
+	return i.Fresh.IsZero() || time.Now().Before(i.Fresh)
+}
+
+func fetchKey(fetchType string, uri string) string {
+	return fetchType + "\t" + uri
+}
@@ -2,19 +2,26 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
-	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
 )
 
 type (
+	// Memory stores the items in a LRU, bounded by a number of items and a size in bytes.
 	Memory struct {
 		Cleanup    int
 		Expiration int
+		MaxEntries int
+		MaxBytes   int
 
-		cache *cache.Cache
+		cache          *ttutils.LRU
+		counters       counters
+		prometheusFire func(*ttprom.PrometheusMetric) error
 
 		logger        *logrus.Entry
 		context       context.Context
@@ -25,30 +32,30 @@ type (
 	}
 
 	MemoryConfigInput struct {
-		Expiration int
-		Cleanup    int
-		Logger     *logrus.Entry
+		Expiration     int
+		Cleanup        int
+		MaxEntries     int
+		MaxBytes       int
+		PrometheusFire func(*ttprom.PrometheusMetric) error
+		Logger         *logrus.Entry
 	}
 )
 
 func NewCacheMemory(cacheConfig *MemoryConfigInput) ICacheCache {
 	c := new(Memory)
-	expiration := cacheConfig.Expiration
-
-	if cacheConfig.Expiration == 0 {
-		expiration = -1
-	}
 
-	c.cache = cache.New(
-		time.Duration(expiration)*time.Second,
-		time.Duration(cacheConfig.Cleanup)*time.Second,
-	)
+	c.cache = ttutils.NewLRU(cacheConfig.MaxEntries, cacheConfig.MaxBytes)
 
-	c.Expiration = expiration
+	c.Expiration = cacheConfig.Expiration
 	c.Cleanup = cacheConfig.Cleanup
+	c.MaxEntries = cacheConfig.MaxEntries
+	c.MaxBytes = cacheConfig.MaxBytes
 
+	c.prometheusFire = cacheConfig.PrometheusFire
 	c.logger = cacheConfig.Logger
 
+	c.cache.SetOnEvict(c.fireEvictionsMetric)
+
 	return c
 }
 
@@ -65,10 +72,46 @@ func (c *Memory) Start() {
 
 	c.isInitialized = true
 
+	if c.Cleanup > 0 {
+		lru := c.cache
+
+		go func() {
+			ticker := time.NewTicker(time.Duration(c.Cleanup) * time.Second)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-listenCtx.Done():
+					return
+				case <-ticker.C:
+					c.logger.
+						Tracef("%d expired items removed", lru.DeleteExpired())
+				}
+			}
+		}()
+	}
+
 	c.logger.
 		Infof("starting... done!")
 }
 
+func (c *Memory) fireEvictionsMetric(evicted int) {
+	c.logger.
+		Debugf("%d items evicted", evicted)
+
+	c.counters.evicted(evicted)
+
+	if err := c.prometheusFire(&ttprom.PrometheusMetric{
+		Metric: ttprom.PrometheusCacheEvictionsCounter,
+		Labels: []string{"memory"},
+		Action: "add",
+		Values: float64(evicted),
+	}); err != nil {
+		c.logger.
+			Errorf("firing prometheus failed -> %s", err)
+	}
+}
+
 func (c *Memory) Flush() {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -104,6 +147,14 @@ func (c *Memory) Shutdown() {
 }
 
 func (c *Memory) Get(key string) (*Item, bool) {
+	item, ok := c.get(key)
+	c.counters.lookup(ok)
+
+	return item, ok
+}
+
+// get returns the item without counting a hit or a miss.
+func (c *Memory) get(key string) (*Item, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -121,18 +172,27 @@ func (c *Memory) Replace(key string, value *Item, d time.Duration) error {
 	defer c.mu.RUnlock()
 
 	if d >= 0 && c.cache != nil && c.isInitialized {
-		if d == 0 {
-			d = -1
+		size := itemSize(key, value)
+
+		if c.MaxBytes > 0 && size > c.MaxBytes {
+			c.logger.
+				Debugf("item %s not stored: %d bytes is larger than the cache", key, size)
+
+			c.cache.Delete(key)
+
+			return nil
 		}
 
-		return c.cache.Replace(key, value, d)
+		if !c.cache.Replace(key, value, size, itemExpiration(d)) {
+			return fmt.Errorf("item %s doesn't exist", key)
+		}
 	}
 
 	return nil
 }
 
 func (c *Memory) ReplaceIfExists(key string, value *Item, d time.Duration) error {
-	if _, ok := c.Get(key); ok {
+	if _, ok := c.get(key); ok {
 		return c.Replace(key, value, d)
 	}
 
@@ -148,21 +208,69 @@ func (c *Memory) Delete(key string) {
 	}
 }
 
+func (c *Memory) DeleteFunc(match func(key string) bool) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.cache != nil && c.isInitialized {
+		return c.cache.DeleteFunc(match)
+	}
+
+	return 0
+}
+
 func (c *Memory) Add(key string, value *Item, d time.Duration) error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if d >= 0 && c.cache != nil && c.isInitialized {
-		if d == 0 {
-			d = -1
+		size := itemSize(key, value)
+
+		if c.MaxBytes > 0 && size > c.MaxBytes {
+			c.logger.
+				Debugf("item %s not stored: %d bytes is larger than the cache", key, size)
+
+			return nil
 		}
 
-		return c.cache.Add(key, value, d)
+		if !c.cache.Add(key, value, size, itemExpiration(d)) {
+			return fmt.Errorf("item %s already exists", key)
+		}
 	}
 
 	return nil
 }
 
+func (c *Memory) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.cache == nil || !c.isInitialized {
+		return c.counters.stats("memory", 0, 0)
+	}
+
+	stats := c.counters.stats("memory", c.cache.Len(), c.cache.Bytes())
+	stats.Expirations = uint64(c.cache.Expired())
+
+	return stats
+}
+
+func (c *Memory) Keys() []KeyInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var keys []KeyInfo
+
+	if c.cache != nil && c.isInitialized {
+		c.cache.Range(func(key string, value interface{}, size int, expiration int64) bool {
+			keys = append(keys, KeyInfo{Key: key, Size: size, TTL: ttl(expiration)})
+			return true
+		})
+	}
+
+	return keys
+}
+
 func (c *Memory) IsEnabled() bool {
 	if c.cache != nil && c.isInitialized {
 		return c.Expiration > -1
@@ -174,3 +282,21 @@ func (c *Memory) IsEnabled() bool {
 func (c *Memory) IsDisabled() bool {
 	return !c.IsEnabled()
 }
+
+// itemSize is the number of bytes of the key and of the data, if the data is a []byte.
+func itemSize(key string, value *Item) int {
+	if data, ok := value.Item.([]byte); ok {
+		return len(key) + len(data)
+	}
+
+	return len(key)
+}
+
+// itemExpiration is the Unix time, in nanoseconds, of the expiration (0 means no expiration).
+func itemExpiration(d time.Duration) int64 {
+	if d > 0 {
+		return time.Now().Add(d).UnixNano()
+	}
+
+	return 0
+}
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"testing"
+
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+)
+
+func newTestCacheMemory(expiration int) ICacheCache {
+	c := NewCacheMemory(&MemoryConfigInput{
+		Expiration:     expiration,
+		PrometheusFire: func(*ttprom.PrometheusMetric) error { return nil },
+		Logger:         testLogger(),
+	})
+	c.Start()
+
+	return c
+}
+
+func TestCacheMemory(t *testing.T) {
+	c := newTestCacheMemory(300)
+	defer c.Shutdown()
+
+	testStore(t, c)
+}
+
+func TestCacheMemoryExpiration(t *testing.T) {
+	testExpiration(t, newTestCacheMemory)
+}
@@ -0,0 +1,576 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+type (
+	// Redis stores the items in a Redis server, to share them between several instances.
+	// The cache is disabled while the server can't be reached.
+	Redis struct {
+		Config     *ttutils.CacheRedisConfig
+		Expiration int
+
+		pool     *redis.Pool
+		counters counters
+
+		logger *logrus.Entry
+
+		mu            sync.RWMutex
+		isInitialized bool
+
+		muAvailability   sync.Mutex
+		unavailable      bool
+		unavailableUntil time.Time
+	}
+
+	RedisConfigInput struct {
+		Config     *ttutils.CacheRedisConfig
+		Expiration int
+		Logger     *logrus.Entry
+	}
+)
+
+var errRedisUnavailable = errors.New("redis is unavailable")
+
+func NewCacheRedis(cacheConfig *RedisConfigInput) ICacheCache {
+	c := new(Redis)
+
+	c.Config = cacheConfig.Config
+	c.Expiration = cacheConfig.Expiration
+
+	c.logger = cacheConfig.Logger
+
+	return c
+}
+
+func (c *Redis) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.
+		Infof("starting...")
+
+	address := ttutils.StringValue(c.Config.Address)
+	timeout := time.Duration(ttutils.IntValue(c.Config.Timeout)) * time.Second
+
+	options := []redis.DialOption{
+		redis.DialDatabase(ttutils.IntValue(c.Config.DB)),
+		redis.DialUsername(ttutils.StringValue(c.Config.Username)),
+		redis.DialPassword(ttutils.StringValue(c.Config.Password)),
+		redis.DialConnectTimeout(timeout),
+		redis.DialReadTimeout(timeout),
+		redis.DialWriteTimeout(timeout),
+		redis.DialTLSHandshakeTimeout(timeout),
+	}
+
+	if c.Config.TLS != nil {
+		tlsConfig, err := c.configureTLS()
+		if err != nil {
+			c.logger.
+				Errorf("unable to configure TLS, the cache is disabled -> %s", err)
+
+			return
+		}
+
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+	}
+
+	c.pool = &redis.Pool{
+		MaxIdle:     16,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, options...)
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Minute {
+				return nil
+			}
+
+			_, err := conn.Do("PING")
+
+			return err
+		},
+	}
+
+	c.isInitialized = true
+
+	if _, err := c.do("PING"); err == nil {
+		c.logger.
+			Infof("using redis %s (db %d)", address, ttutils.IntValue(c.Config.DB))
+	}
+
+	c.logger.
+		Infof("starting... done!")
+}
+
+func (c *Redis) configureTLS() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: ttutils.BoolValue(c.Config.TLS.InsecureSkipVerify),
+	}
+
+	if ttutils.NotStringEmpty(c.Config.TLS.CA) {
+		caBundle, err := ioutil.ReadFile(ttutils.StringValue(c.Config.TLS.CA))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA bundle: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("unable to find any certificate in the CA bundle %s", ttutils.StringValue(c.Config.TLS.CA))
+		}
+	}
+
+	if ttutils.NotStringEmpty(c.Config.TLS.Cert) {
+		cert, err := tls.LoadX509KeyPair(ttutils.StringValue(c.Config.TLS.Cert), ttutils.StringValue(c.Config.TLS.Key))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %s", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// do runs a command, unless the server has failed recently:
+// a failure disables the cache for the retry delay.
+func (c *Redis) do(command string, args ...interface{}) (interface{}, error) {
+	if !c.isAvailable() {
+		return nil, errRedisUnavailable
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do(command, args...)
+	if err != nil && err != redis.ErrNil {
+		// the idle connections are closed when the server restarts
+		if freshConn, dialErr := c.pool.Dial(); dialErr == nil {
+			defer freshConn.Close()
+
+			reply, err = freshConn.Do(command, args...)
+		}
+	}
+
+	if err != nil && err != redis.ErrNil {
+		c.setUnavailable(err)
+
+		return nil, err
+	}
+
+	c.setAvailable()
+
+	return reply, err
+}
+
+func (c *Redis) isAvailable() bool {
+	c.muAvailability.Lock()
+	defer c.muAvailability.Unlock()
+
+	return !c.unavailable || time.Now().After(c.unavailableUntil)
+}
+
+func (c *Redis) setUnavailable(err error) {
+	c.muAvailability.Lock()
+	defer c.muAvailability.Unlock()
+
+	retry := ttutils.IntValue(c.Config.Retry)
+
+	if !c.unavailable {
+		c.logger.
+			Warnf("redis is unavailable, the cache is disabled for %ds -> %s", retry, err)
+	} else {
+		c.logger.
+			Debugf("redis is still unavailable -> %s", err)
+	}
+
+	c.unavailable = true
+	c.unavailableUntil = time.Now().Add(time.Duration(retry) * time.Second)
+}
+
+func (c *Redis) setAvailable() {
+	c.muAvailability.Lock()
+	defer c.muAvailability.Unlock()
+
+	if c.unavailable {
+		c.logger.
+			Infof("redis is available again, the cache is enabled")
+
+		c.unavailable = false
+	}
+}
+
+func (c *Redis) key(key string) string {
+	return ttutils.StringValue(c.Config.Prefix) + key
+}
+
+func (c *Redis) Flush() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.logger.
+		Debugf("flushing...")
+
+	if c.isInitialized {
+		c.deleteFunc(func(key string) bool { return true })
+	}
+
+	c.logger.
+		Debugf("flushing... done!")
+}
+
+// deleteFunc deletes the keys of this cache that match (without the prefix), the database may be shared.
+// The lock must be held.
+func (c *Redis) deleteFunc(match func(key string) bool) int {
+	prefix := ttutils.StringValue(c.Config.Prefix)
+	deleted := 0
+
+	c.scan(func(keys []string) bool {
+		var matched []interface{}
+		for _, key := range keys {
+			if match(strings.TrimPrefix(key, prefix)) {
+				matched = append(matched, key)
+			}
+		}
+
+		if len(matched) != 0 {
+			if _, err := c.do("DEL", matched...); err != nil {
+				c.logger.
+					Errorf("unable to delete the keys -> %s", err)
+
+				return false
+			}
+
+			deleted += len(matched)
+		}
+
+		return true
+	})
+
+	return deleted
+}
+
+// scan calls f with the keys of this cache (with the prefix), by batch, until f returns false.
+// The lock must be held.
+func (c *Redis) scan(f func(keys []string) bool) {
+	pattern := escapeRedisPattern(ttutils.StringValue(c.Config.Prefix)) + "*"
+	cursor := 0
+
+	for {
+		values, err := redis.Values(c.do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			c.logger.
+				Errorf("unable to list the keys -> %s", err)
+
+			break
+		}
+
+		var replyKeys []interface{}
+		if _, err := redis.Scan(values, &cursor, &replyKeys); err != nil {
+			c.logger.
+				Errorf("unable to list the keys -> %s", err)
+
+			break
+		}
+
+		keys, err := redis.Strings(replyKeys, nil)
+		if err != nil {
+			c.logger.
+				Errorf("unable to list the keys -> %s", err)
+
+			break
+		}
+
+		if !f(keys) || cursor == 0 {
+			break
+		}
+	}
+}
+
+// Shutdown stops the cache, the items are kept in Redis.
+func (c *Redis) Shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.
+		Infof("stopping...")
+
+	if c.isInitialized {
+		c.pool.Close()
+		c.isInitialized = false
+	}
+
+	c.logger.
+		Infof("stopping... done!")
+}
+
+func (c *Redis) Get(key string) (*Item, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, false
+	}
+
+	data, err := redis.Bytes(c.do("GET", c.key(key)))
+	if err != nil {
+		c.counters.lookup(false)
+		return nil, false
+	}
+
+	item, err := decodeItem(data)
+	if err != nil {
+		c.logger.
+			Errorf("unable to decode the item %s -> %s", key, err)
+
+		c.counters.lookup(false)
+
+		return nil, false
+	}
+
+	c.counters.lookup(item.Key == key)
+
+	if item.Key != key {
+		return nil, false
+	}
+
+	return item.item(), true
+}
+
+func (c *Redis) Replace(key string, value *Item, d time.Duration) error {
+	if ok, err := c.set(key, value, d, "XX"); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("item %s doesn't exist", key)
+	}
+
+	return nil
+}
+
+func (c *Redis) ReplaceIfExists(key string, value *Item, d time.Duration) error {
+	_, err := c.set(key, value, d, "")
+
+	return err
+}
+
+func (c *Redis) Delete(key string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.isInitialized {
+		if _, err := c.do("DEL", c.key(key)); err != nil && err != errRedisUnavailable {
+			c.logger.
+				Errorf("unable to delete the item %s -> %s", key, err)
+		}
+	}
+}
+
+func (c *Redis) DeleteFunc(match func(key string) bool) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return 0
+	}
+
+	return c.deleteFunc(match)
+}
+
+func (c *Redis) Add(key string, value *Item, d time.Duration) error {
+	if ok, err := c.set(key, value, d, "NX"); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("item %s already exists", key)
+	}
+
+	return nil
+}
+
+// set stores the item with the SET condition (NX, XX or none), it tells if the condition was met.
+// Nothing is stored (and no error is returned) while the server is unavailable.
+func (c *Redis) set(key string, value *Item, d time.Duration, condition string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if d < 0 || !c.isInitialized {
+		return true, nil
+	}
+
+	data, err := encodeItem(key, value, 0)
+	if err != nil {
+		return false, err
+	}
+
+	args := []interface{}{c.key(key), data}
+
+	if d > 0 {
+		args = append(args, "PX", d.Milliseconds())
+	}
+
+	if condition != "" {
+		args = append(args, condition)
+	}
+
+	// the failures have already been reported by do()
+	reply, err := c.do("SET", args...)
+	if err == redis.ErrNil || (err == nil && reply == nil) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Stats counts the keys of this cache, the evictions and the expirations are done by Redis and aren't counted.
+func (c *Redis) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var entries, bytes int
+
+	if c.isInitialized {
+		if err := c.scanInfo(false, func(batch []KeyInfo) {
+			for _, key := range batch {
+				entries++
+				bytes += key.Size
+			}
+		}); err != nil {
+			c.logger.
+				Warnf("unable to count the keys -> %s", err)
+		}
+	}
+
+	return c.counters.stats("redis", entries, bytes)
+}
+
+// Keys lists the keys of this cache (without the prefix), the database may be shared.
+func (c *Redis) Keys() []KeyInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil
+	}
+
+	var keys []KeyInfo
+
+	if err := c.scanInfo(true, func(batch []KeyInfo) {
+		keys = append(keys, batch...)
+	}); err != nil {
+		c.logger.
+			Warnf("unable to list the keys -> %s", err)
+	}
+
+	return keys
+}
+
+// scanInfo calls f with the keys of this cache (without the prefix) and their size, and with ttl their remaining
+// time before the expiration, by batch. The commands of a batch are pipelined on a single connection.
+// Unlike do(), a failure doesn't disable the cache: the pages can still be served.
+// The lock must be held.
+func (c *Redis) scanInfo(ttl bool, f func(batch []KeyInfo)) error {
+	if !c.isAvailable() {
+		return errRedisUnavailable
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	prefix := ttutils.StringValue(c.Config.Prefix)
+	pattern := escapeRedisPattern(prefix) + "*"
+	cursor := 0
+
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+
+		var replyKeys []interface{}
+		if _, err := redis.Scan(values, &cursor, &replyKeys); err != nil {
+			return err
+		}
+
+		keys, err := redis.Strings(replyKeys, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if ttl {
+				if err := conn.Send("PTTL", key); err != nil {
+					return err
+				}
+			}
+
+			if err := conn.Send("STRLEN", key); err != nil {
+				return err
+			}
+		}
+
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+
+		batch := make([]KeyInfo, 0, len(keys))
+
+		for _, key := range keys {
+			info := KeyInfo{Key: strings.TrimPrefix(key, prefix), TTL: -1}
+
+			if ttl {
+				pttl, err := redis.Int64(conn.Receive())
+				if err != nil {
+					return err
+				}
+
+				if pttl >= 0 {
+					info.TTL = time.Duration(pttl) * time.Millisecond
+				}
+			}
+
+			if info.Size, err = redis.Int(conn.Receive()); err != nil {
+				return err
+			}
+
+			// the key has expired since the scan
+			if info.Size == 0 {
+				continue
+			}
+
+			batch = append(batch, info)
+		}
+
+		f(batch)
+
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (c *Redis) IsEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.isInitialized && c.Expiration > -1 && c.isAvailable()
+}
+
+func (c *Redis) IsDisabled() bool {
+	return !c.IsEnabled()
+}
+
+func escapeRedisPattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
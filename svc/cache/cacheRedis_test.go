@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+// newTestCacheRedis needs a Redis server, its address is given by TTSERVER_TEST_REDIS
+// (e.g. TTSERVER_TEST_REDIS=127.0.0.1:6379). The items are stored with a unique prefix.
+func newTestCacheRedis(t *testing.T, expiration int) ICacheCache {
+	address := os.Getenv("TTSERVER_TEST_REDIS")
+	if address == "" {
+		t.Skip("TTSERVER_TEST_REDIS is not set")
+	}
+
+	c := NewCacheRedis(&RedisConfigInput{
+		Config: &ttutils.CacheRedisConfig{
+			Address: ttutils.String(address),
+			Prefix:  ttutils.String(fmt.Sprintf("ttserver-test-%d:", time.Now().UnixNano())),
+			Timeout: ttutils.Int(5),
+		},
+		Expiration: expiration,
+		Logger:     testLogger(),
+	})
+
+	c.Start()
+
+	return c
+}
+
+func TestCacheRedis(t *testing.T) {
+	c := newTestCacheRedis(t, 300)
+	defer c.Shutdown()
+
+	if c.IsDisabled() {
+		t.Fatalf("the cache is disabled")
+	}
+
+	defer c.Flush()
+
+	testStore(t, c)
+}
+
+func TestCacheRedisExpiration(t *testing.T) {
+	testExpiration(t, func(expiration int) ICacheCache {
+		return newTestCacheRedis(t, expiration)
+	})
+}
+
+func TestCacheRedisUnavailable(t *testing.T) {
+	c := NewCacheRedis(&RedisConfigInput{
+		Config: &ttutils.CacheRedisConfig{
+			// nothing listens on the discard port
+			Address: ttutils.String("127.0.0.1:9"),
+			Timeout: ttutils.Int(1),
+		},
+		Logger: testLogger(),
+	})
+
+	c.Start()
+	defer c.Shutdown()
+
+	// nothing is stored and nothing fails while the server is unavailable
+	if err := c.Add("index", &Item{Item: []byte("hello")}, time.Minute); err != nil {
+		t.Errorf("unable to add: %s", err)
+	}
+
+	if _, ok := c.Get("index"); ok {
+		t.Errorf("an item is found")
+	}
+
+	if keys := c.Keys(); len(keys) != 0 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return logrus.NewEntry(logger)
+}
+
+func TestEncodeItem(t *testing.T) {
+	fresh := time.Unix(0, time.Now().Add(time.Minute).UnixNano())
+
+	tests := []struct {
+		name       string
+		item       *Item
+		expiration int64
+	}{
+		{"always fresh", &Item{Item: []byte("hello"), Code: "200"}, 0},
+		{"fresh until", &Item{Item: []byte("hello"), Code: "200", Fresh: fresh}, fresh.Add(time.Hour).UnixNano()},
+		{"empty", &Item{Item: []byte{}, Code: "404"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encodeItem("index", tt.item, tt.expiration)
+			if err != nil {
+				t.Fatalf("unable to encode: %s", err)
+			}
+
+			stored, err := decodeItem(data)
+			if err != nil {
+				t.Fatalf("unable to decode: %s", err)
+			}
+
+			if stored.Key != "index" || stored.Expiration != tt.expiration {
+				t.Errorf("decoded key %q and expiration %d, wanted %q and %d", stored.Key, stored.Expiration, "index", tt.expiration)
+			}
+
+			if diff := cmp.Diff(tt.item, stored.item(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected item (-wanted +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEncodeItemInvalid(t *testing.T) {
+	if _, err := encodeItem("index", &Item{Item: "hello"}, 0); err == nil {
+		t.Errorf("an item other than []byte is encoded")
+	}
+
+	if _, err := decodeItem([]byte("garbage")); err == nil {
+		t.Errorf("garbage is decoded")
+	}
+}
+
+// testStore runs the same scenario against a started store.
+func testStore(t *testing.T, c ICacheCache) {
+	item := &Item{Item: []byte("hello"), Code: "200"}
+
+	if _, ok := c.Get("index"); ok {
+		t.Fatalf("the store isn't empty")
+	}
+
+	if err := c.Replace("index", item, 0); err == nil {
+		t.Errorf("a missing item is replaced")
+	}
+
+	if err := c.Add("index", item, 0); err != nil {
+		t.Fatalf("unable to add: %s", err)
+	}
+
+	if err := c.Add("index", item, 0); err == nil {
+		t.Errorf("an existing item is added")
+	}
+
+	got, ok := c.Get("index")
+	if !ok {
+		t.Fatalf("the item isn't found")
+	}
+
+	if diff := cmp.Diff(item, got); diff != "" {
+		t.Errorf("unexpected item (-wanted +got):\n%s", diff)
+	}
+
+	updated := &Item{Item: []byte("world"), Code: "200", Fresh: time.Unix(0, time.Now().Add(time.Minute).UnixNano())}
+
+	if err := c.Replace("index", updated, time.Hour); err != nil {
+		t.Errorf("unable to replace: %s", err)
+	}
+
+	if got, _ := c.Get("index"); !cmp.Equal(updated, got) {
+		t.Errorf("the item isn't replaced: %v", got)
+	}
+
+	// a negative duration isn't stored
+	if err := c.ReplaceIfExists("skipped", item, -1); err != nil {
+		t.Errorf("unable to skip: %s", err)
+	}
+
+	if err := c.ReplaceIfExists("blog/2020", item, time.Hour); err != nil {
+		t.Errorf("unable to store: %s", err)
+	}
+
+	keys := map[string]time.Duration{}
+	for _, key := range c.Keys() {
+		keys[key.Key] = key.TTL
+	}
+
+	if len(keys) != 2 || keys["index"] <= 0 || keys["blog/2020"] <= 0 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	if deleted := c.DeleteFunc(func(key string) bool { return key == "blog/2020" }); deleted != 1 {
+		t.Errorf("%d items deleted, wanted 1", deleted)
+	}
+
+	c.Delete("index")
+
+	if _, ok := c.Get("index"); ok {
+		t.Errorf("the item isn't deleted")
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 0 || stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// testExpiration checks the default expiration of a store: -1 disables it and 0 means unlimited.
+func testExpiration(t *testing.T, newStore func(expiration int) ICacheCache) {
+	disabled := newStore(-1)
+	defer disabled.Shutdown()
+
+	if disabled.IsEnabled() {
+		t.Errorf("the store is enabled with the expiration -1")
+	}
+
+	c := newStore(0)
+	defer c.Shutdown()
+
+	if c.IsDisabled() {
+		t.Fatalf("the store is disabled with the expiration 0")
+	}
+
+	if err := c.ReplaceIfExists("index", &Item{Item: []byte("hello")}, 0); err != nil {
+		t.Fatalf("unable to store: %s", err)
+	}
+
+	if keys := c.Keys(); len(keys) != 1 || keys[0].TTL != -1 {
+		t.Errorf("the item expires: %v", keys)
+	}
+
+	c.Delete("index")
+}
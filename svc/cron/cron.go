@@ -15,8 +15,9 @@ import (
 
 type (
 	CronCron struct {
-		config *ttutils.ConfigRoot
-		cache  func() ttcache.ICacheCache
+		config     *ttutils.ConfigRoot
+		cache      func() ttcache.ICacheCache
+		fetchCache func() *ttcache.Fetch
 
 		cron *cron.Cron
 
@@ -34,6 +35,7 @@ type (
 		ServeConnHandler tthandler.IServeConnHandler
 		Config           *ttutils.ConfigRoot
 		Cache            func() ttcache.ICacheCache
+		FetchCache       func() *ttcache.Fetch
 		PrometheusFire   func(metric *ttprom.PrometheusMetric) error
 		Logger           *logrus.Entry
 	}
@@ -44,6 +46,7 @@ func NewCron(cronConfig *ConfigInput) (*CronCron, error) {
 		config:           cronConfig.Config,
 		prometheusFire:   cronConfig.PrometheusFire,
 		cache:            cronConfig.Cache,
+		fetchCache:       cronConfig.FetchCache,
 		cron:             cron.New(cron.WithSeconds()),
 		serveConnHandler: cronConfig.ServeConnHandler,
 		logger:           cronConfig.Logger,
@@ -73,6 +76,7 @@ func NewCron(cronConfig *ConfigInput) (*CronCron, error) {
 				PrometheusFire: c.prometheusFire,
 				Config:         c.config,
 				Cache:          c.cache,
+				FetchCache:     c.fetchCache,
 
 				Start:         time.Now(),
 				LocalAddress:  ttutils.StringValue(c.config.Space.Listener.Address),
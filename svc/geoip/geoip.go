@@ -0,0 +1,192 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+type (
+	// GeoIP looks up the country and the ASN of the IPs in local MaxMind databases.
+	GeoIP struct {
+		config *ttutils.ConfigRoot
+		logger *logrus.Entry
+
+		readers []*maxminddb.Reader
+
+		mu sync.RWMutex
+	}
+
+	ConfigInput struct {
+		Config *ttutils.ConfigRoot
+		Logger *logrus.Entry
+	}
+
+	// record holds the fields of the GeoIP2/GeoLite2 Country, City and ASN databases.
+	record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+
+		ASN            uint   `maxminddb:"autonomous_system_number"`
+		ASOrganization string `maxminddb:"autonomous_system_organization"`
+	}
+)
+
+func NewGeoIP(geoIPConfig *ConfigInput) *GeoIP {
+	g := GeoIP{
+		config: geoIPConfig.Config,
+		logger: geoIPConfig.Logger,
+	}
+
+	return &g
+}
+
+func (g *GeoIP) Initialize() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	readers, err := g.open(g.config)
+	if err != nil {
+		return err
+	}
+
+	g.readers = readers
+
+	return nil
+}
+
+func (g *GeoIP) open(config *ttutils.ConfigRoot) ([]*maxminddb.Reader, error) {
+	var readers []*maxminddb.Reader
+
+	if config.Space.GeoIP == nil {
+		return nil, nil
+	}
+
+	for _, path := range config.Space.GeoIP.Databases {
+		g.logger.
+			Debugf("opening %s...", path)
+
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			closeReaders(readers)
+			return nil, fmt.Errorf("unable to open the GeoIP database %s: %s", path, err)
+		}
+
+		g.logger.
+			Infof("using the GeoIP database %s (%s, built on %d)", path, reader.Metadata.DatabaseType, reader.Metadata.BuildEpoch)
+
+		readers = append(readers, reader)
+	}
+
+	return readers, nil
+}
+
+// Reset opens the databases again, the files may have been updated:
+// the previous databases are kept if the new ones can't be opened.
+func (g *GeoIP) Reset(newConfig *ttutils.ConfigRoot) (*GeoIP, error) {
+	g.logger.
+		Debugf("reloading...")
+
+	readers, err := g.open(newConfig)
+	if err != nil {
+		return g, err
+	}
+
+	g.mu.Lock()
+	oldReaders := g.readers
+	g.readers = readers
+	g.config = newConfig
+	g.mu.Unlock()
+
+	closeReaders(oldReaders)
+
+	g.logger.
+		Debugf("reloading... done!")
+
+	return g, nil
+}
+
+func (g *GeoIP) Shutdown() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.logger.
+		Infof("stopping...")
+
+	closeReaders(g.readers)
+	g.readers = nil
+
+	g.logger.
+		Infof("stopping... done!")
+}
+
+// IsEnabled tells if there is at least one database.
+func (g *GeoIP) IsEnabled() bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return len(g.readers) != 0
+}
+
+// Lookup returns what the databases know about the IP, nil if nothing is known.
+// The first database knowing a field has precedence.
+func (g *GeoIP) Lookup(ip string) *ttutils.GeoIPInfo {
+	if g == nil {
+		return nil
+	}
+
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if len(g.readers) == 0 {
+		return nil
+	}
+
+	info := ttutils.GeoIPInfo{}
+
+	for _, reader := range g.readers {
+		var r record
+
+		if err := reader.Lookup(parsedIP, &r); err != nil {
+			g.logger.
+				Debugf("unable to look up %s -> %s", ip, err)
+
+			continue
+		}
+
+		if info.Country == "" {
+			info.Country = r.Country.ISOCode
+		}
+
+		if info.ASN == 0 {
+			info.ASN = r.ASN
+			info.ASOrganization = r.ASOrganization
+		}
+	}
+
+	if info.Country == "" && info.ASN == 0 {
+		return nil
+	}
+
+	return &info
+}
+
+func closeReaders(readers []*maxminddb.Reader) {
+	for _, reader := range readers {
+		reader.Close()
+	}
+}
@@ -3,6 +3,7 @@ package prometheus
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -26,13 +27,16 @@ type (
 		config *ttutils.ConfigRoot
 		logger *logrus.Entry
 
-		address   string
-		endpoint  string
-		authBasic *PrometheusAuthBasic
+		address      string
+		endpoint     string
+		admin        string
+		adminHandler http.Handler
+		authBasic    *PrometheusAuthBasic
 
 		summaryMaxAge int
 
 		httpServer  *http.Server
+		listener    net.Listener
 		metricsChan chan *PrometheusMetric
 
 		context       context.Context
@@ -47,16 +51,33 @@ type (
 	}
 
 	PrometheusConfigInput struct {
-		Config *ttutils.ConfigRoot
-		Logger *logrus.Entry
+		Config       *ttutils.ConfigRoot
+		AdminHandler http.Handler
+		Logger       *logrus.Entry
 	}
 )
 
 var (
 	PrometheusRouteCacheStatusCounter *prometheus.CounterVec
+	PrometheusCacheEvictionsCounter   *prometheus.CounterVec
+	PrometheusRouteCoalescedCounter   *prometheus.CounterVec
+	PrometheusCacheEntriesGauge       *prometheus.GaugeVec
+	PrometheusCacheBytesGauge         *prometheus.GaugeVec
+	PrometheusCacheHitsCounter        *prometheus.CounterVec
+	PrometheusCacheMissesCounter      *prometheus.CounterVec
+	PrometheusCacheExpirationsCounter *prometheus.CounterVec
 	PrometheusActiveConnGauge         *prometheus.GaugeVec
-	PrometheusProcessDurationSummary  *prometheus.SummaryVec
-	PrometheusConnDurationSummary     *prometheus.SummaryVec
+	PrometheusConnRejectedCounter     *prometheus.CounterVec
+	PrometheusConnQueuedCounter       *prometheus.CounterVec
+	PrometheusShutdownConnCounter     *prometheus.CounterVec
+	PrometheusGeoIPConnCounter        *prometheus.CounterVec
+	PrometheusTarpitConnCounter       *prometheus.CounterVec
+	PrometheusTarpitActiveConnGauge   *prometheus.GaugeVec
+
+	PrometheusRateLimitRejectedCounter *prometheus.CounterVec
+	PrometheusRateLimitBannedGauge     *prometheus.GaugeVec
+	PrometheusProcessDurationSummary   *prometheus.SummaryVec
+	PrometheusConnDurationSummary      *prometheus.SummaryVec
 
 	shutdownChanPollInterval  = 500 * time.Millisecond
 	shutdownChanTimeout       = 5 * time.Second
@@ -65,20 +86,29 @@ var (
 
 func NewPrometheus(prometheusConfig *PrometheusConfigInput) *PrometheusServer {
 	p := PrometheusServer{
-		config: prometheusConfig.Config,
-		logger: prometheusConfig.Logger,
+		config:       prometheusConfig.Config,
+		adminHandler: prometheusConfig.AdminHandler,
+		logger:       prometheusConfig.Logger,
 	}
 
 	return &p
 }
 
-func (p *PrometheusServer) Initialize() {
+func (p *PrometheusServer) Initialize() error {
 	if p.httpServer == nil && !p.isInitialized {
 		p.logger.
 			Debugf("creating...")
 
 		p.address = p.config.Prometheus.Address
 		p.endpoint = p.config.Prometheus.Endpoint
+		p.admin = p.config.Prometheus.Admin
+
+		ln, err := ttutils.Listen(p.address)
+		if err != nil {
+			return err
+		}
+
+		p.listener = ln
 		p.summaryMaxAge = ttutils.IntValue(p.config.Prometheus.SummaryMaxAge)
 		p.metricsChan = make(chan *PrometheusMetric, ttutils.IntValue(p.config.Prometheus.ChanSize))
 
@@ -175,6 +205,8 @@ func (p *PrometheusServer) Initialize() {
 		p.logger.
 			Debugf("creating... done!")
 	}
+
+	return nil
 }
 
 func (p *PrometheusServer) Reset(newConfig *ttutils.ConfigRoot) (*PrometheusServer, error) {
@@ -183,6 +215,7 @@ func (p *PrometheusServer) Reset(newConfig *ttutils.ConfigRoot) (*PrometheusServ
 	}()
 
 	if (newConfig.Prometheus.Address != p.address || p.config.Prometheus.Endpoint != p.endpoint) ||
+		newConfig.Prometheus.Admin != p.admin ||
 		((newConfig.Prometheus.Auth != nil && newConfig.Prometheus.Auth.AuthBasic != nil) && p.authBasic == nil) ||
 		((newConfig.Prometheus.Auth != nil && newConfig.Prometheus.Auth.AuthBasic == nil) && p.authBasic != nil) ||
 		((newConfig.Prometheus.Auth == nil) && p.authBasic != nil) ||
@@ -229,14 +262,19 @@ func (p *PrometheusServer) Start() error {
 		}
 
 		httpMux.Handle(p.endpoint, httpauth.BasicAuth(authOpts)(promhttp.Handler()))
+
+		if p.admin != "" && p.adminHandler != nil {
+			httpMux.Handle(p.admin+"/", httpauth.BasicAuth(authOpts)(http.StripPrefix(p.admin, p.adminHandler)))
+		}
 	} else {
+		// the admin endpoint is never served without authentication
 		httpMux.Handle(p.endpoint, promhttp.Handler())
 	}
 
 	p.httpServer = &http.Server{Addr: p.address, Handler: httpMux}
 
-	go func() {
-		if err := p.httpServer.ListenAndServe(); err != nil {
+	go func(ln net.Listener) {
+		if err := p.httpServer.Serve(ln); err != nil {
 			select {
 			case <-p.context.Done():
 				errChanPrometheus <- nil
@@ -248,8 +286,9 @@ func (p *PrometheusServer) Start() error {
 		}
 
 		errChanPrometheus <- nil
-	}()
+	}(p.listener)
 
+	p.listener = nil
 	p.isInitialized = true
 
 	p.logger.
@@ -271,6 +310,7 @@ func (p *PrometheusServer) Start() error {
 	ticker := time.NewTicker(shutdownChanPollInterval)
 	defer ticker.Stop()
 
+WAIT:
 	for {
 		if len(p.metricsChan) == 0 {
 			break
@@ -278,7 +318,7 @@ func (p *PrometheusServer) Start() error {
 
 		select {
 		case <-ctxShutdown.Done():
-			break
+			break WAIT
 		case <-ticker.C:
 		}
 	}
@@ -300,12 +340,20 @@ func (p *PrometheusServer) Shutdown() error {
 
 		p.isInitialized = false
 
-		ctxShutdown, _ := context.WithTimeout(p.context, shutdownHTTPServerTimeout)
+		ctxShutdown, ctxShutdownCancel := context.WithTimeout(p.context, shutdownHTTPServerTimeout)
+		defer ctxShutdownCancel()
+
 		if err := p.httpServer.Shutdown(ctxShutdown); err != nil {
 			return fmt.Errorf("unable to shutdown prometheus: %s", err)
 		}
 	}
 
+	// not served yet
+	if p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+
 	p.logger.
 		Infof("stopping... done!")
 
@@ -323,6 +371,69 @@ func (p *PrometheusServer) registerDefaultMetrics() error {
 		[]string{"route", "status"},
 	)
 
+	prometheusMetricCacheEvictionsOpts := prometheus.Opts{
+		Name: "ttserver_cache_evictions_total",
+		Help: "The total number of items evicted to make room per cache",
+	}
+	PrometheusCacheEvictionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricCacheEvictionsOpts),
+		[]string{"cache"},
+	)
+
+	prometheusMetricRouteCoalescedOpts := prometheus.Opts{
+		Name: "ttserver_route_coalesced_total",
+		Help: "The total number of request waiting for the rendering in progress of the same page per route",
+	}
+	PrometheusRouteCoalescedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricRouteCoalescedOpts),
+		[]string{"route"},
+	)
+
+	prometheusMetricCacheEntriesOpts := prometheus.Opts{
+		Name: "ttserver_cache_entries",
+		Help: "The current number of items per cache store",
+	}
+	PrometheusCacheEntriesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts(prometheusMetricCacheEntriesOpts),
+		[]string{"store"},
+	)
+
+	prometheusMetricCacheBytesOpts := prometheus.Opts{
+		Name: "ttserver_cache_bytes",
+		Help: "The current size in bytes of the items per cache store",
+	}
+	PrometheusCacheBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts(prometheusMetricCacheBytesOpts),
+		[]string{"store"},
+	)
+
+	prometheusMetricCacheHitsOpts := prometheus.Opts{
+		Name: "ttserver_cache_hits_total",
+		Help: "The total number of items found per cache store",
+	}
+	PrometheusCacheHitsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricCacheHitsOpts),
+		[]string{"store"},
+	)
+
+	prometheusMetricCacheMissesOpts := prometheus.Opts{
+		Name: "ttserver_cache_misses_total",
+		Help: "The total number of items not found per cache store",
+	}
+	PrometheusCacheMissesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricCacheMissesOpts),
+		[]string{"store"},
+	)
+
+	prometheusMetricCacheExpirationsOpts := prometheus.Opts{
+		Name: "ttserver_cache_expirations_total",
+		Help: "The total number of expired items removed per cache store",
+	}
+	PrometheusCacheExpirationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricCacheExpirationsOpts),
+		[]string{"store"},
+	)
+
 	prometheusMetricActiveConnOpts := prometheus.Opts{
 		Name: "ttserver_active_conn",
 		Help: "The current number of active connections",
@@ -332,6 +443,78 @@ func (p *PrometheusServer) registerDefaultMetrics() error {
 		[]string{},
 	)
 
+	prometheusMetricConnRejectedOpts := prometheus.Opts{
+		Name: "ttserver_conn_rejected_total",
+		Help: "The total number of connections rejected per reason",
+	}
+	PrometheusConnRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricConnRejectedOpts),
+		[]string{"reason"},
+	)
+
+	prometheusMetricConnQueuedOpts := prometheus.Opts{
+		Name: "ttserver_conn_queued_total",
+		Help: "The total number of connections queued per reason",
+	}
+	PrometheusConnQueuedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricConnQueuedOpts),
+		[]string{"reason"},
+	)
+
+	prometheusMetricShutdownConnOpts := prometheus.Opts{
+		Name: "ttserver_shutdown_conn_total",
+		Help: "The total number of connections ended by a shutdown of the listener per outcome",
+	}
+	PrometheusShutdownConnCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricShutdownConnOpts),
+		[]string{"outcome"},
+	)
+
+	prometheusMetricGeoIPConnOpts := prometheus.Opts{
+		Name: "ttserver_geoip_conn_total",
+		Help: "The total number of connections per country",
+	}
+	PrometheusGeoIPConnCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricGeoIPConnOpts),
+		[]string{"country"},
+	)
+
+	prometheusMetricTarpitConnOpts := prometheus.Opts{
+		Name: "ttserver_tarpit_conn_total",
+		Help: "The total number of connections sent to the tarpit per reason and per outcome",
+	}
+	PrometheusTarpitConnCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricTarpitConnOpts),
+		[]string{"reason", "outcome"},
+	)
+
+	prometheusMetricTarpitActiveConnOpts := prometheus.Opts{
+		Name: "ttserver_tarpit_active_conn",
+		Help: "The current number of connections held in the tarpit",
+	}
+	PrometheusTarpitActiveConnGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts(prometheusMetricTarpitActiveConnOpts),
+		[]string{},
+	)
+
+	prometheusMetricRateLimitRejectedOpts := prometheus.Opts{
+		Name: "ttserver_ratelimit_rejected_total",
+		Help: "The total number of requests rejected by the rate limiter per route and per reason",
+	}
+	PrometheusRateLimitRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts(prometheusMetricRateLimitRejectedOpts),
+		[]string{"route", "reason"},
+	)
+
+	prometheusMetricRateLimitBannedOpts := prometheus.Opts{
+		Name: "ttserver_ratelimit_banned",
+		Help: "The current number of banned remote IPs",
+	}
+	PrometheusRateLimitBannedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts(prometheusMetricRateLimitBannedOpts),
+		[]string{},
+	)
+
 	prometheusMetricProcessDurationOpts := prometheus.SummaryOpts{
 		Name:       "ttserver_process_duration_microseconds",
 		Help:       "The process duration in microseconds",
@@ -356,8 +539,23 @@ func (p *PrometheusServer) registerDefaultMetrics() error {
 
 	// register
 	for collector, opts := range map[prometheus.Collector]prometheus.Opts{
-		PrometheusRouteCacheStatusCounter: prometheusMetricRouteCacheStatusOpts,
-		PrometheusActiveConnGauge:         prometheusMetricActiveConnOpts,
+		PrometheusRouteCacheStatusCounter:  prometheusMetricRouteCacheStatusOpts,
+		PrometheusCacheEvictionsCounter:    prometheusMetricCacheEvictionsOpts,
+		PrometheusRouteCoalescedCounter:    prometheusMetricRouteCoalescedOpts,
+		PrometheusCacheEntriesGauge:        prometheusMetricCacheEntriesOpts,
+		PrometheusCacheBytesGauge:          prometheusMetricCacheBytesOpts,
+		PrometheusCacheHitsCounter:         prometheusMetricCacheHitsOpts,
+		PrometheusCacheMissesCounter:       prometheusMetricCacheMissesOpts,
+		PrometheusCacheExpirationsCounter:  prometheusMetricCacheExpirationsOpts,
+		PrometheusActiveConnGauge:          prometheusMetricActiveConnOpts,
+		PrometheusConnRejectedCounter:      prometheusMetricConnRejectedOpts,
+		PrometheusConnQueuedCounter:        prometheusMetricConnQueuedOpts,
+		PrometheusShutdownConnCounter:      prometheusMetricShutdownConnOpts,
+		PrometheusGeoIPConnCounter:         prometheusMetricGeoIPConnOpts,
+		PrometheusTarpitConnCounter:        prometheusMetricTarpitConnOpts,
+		PrometheusTarpitActiveConnGauge:    prometheusMetricTarpitActiveConnOpts,
+		PrometheusRateLimitRejectedCounter: prometheusMetricRateLimitRejectedOpts,
+		PrometheusRateLimitBannedGauge:     prometheusMetricRateLimitBannedOpts,
 	} {
 		if err := prometheus.Register(collector); err != nil {
 			return fmt.Errorf("unable to register %s, %s", opts.Name, err)
@@ -0,0 +1,354 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+type (
+	// RateLimiter is a token-bucket rate limiter keyed by remote IP.
+	// Its state (buckets and bans) is kept across the reloads.
+	RateLimiter struct {
+		config         *ttutils.ConfigRoot
+		prometheusFire func(*ttprom.PrometheusMetric) error
+		logger         *logrus.Entry
+
+		rate  float64
+		burst int
+		ban   *banPolicy
+
+		buckets   map[string]*bucket
+		offenders map[string]*offender
+
+		context       context.Context
+		contextCancel context.CancelFunc
+
+		mu            sync.Mutex
+		isInitialized bool
+	}
+
+	ConfigInput struct {
+		Config         *ttutils.ConfigRoot
+		PrometheusFire func(*ttprom.PrometheusMetric) error
+		Logger         *logrus.Entry
+	}
+
+	// Ban is a temporary ban of a remote IP.
+	Ban struct {
+		IP    string
+		Count int
+		Until time.Time
+	}
+
+	bucket struct {
+		tokens float64
+		last   time.Time
+		full   time.Time
+	}
+
+	offender struct {
+		violations  int
+		bans        int
+		bannedUntil time.Time
+		lastSeen    time.Time
+	}
+
+	banPolicy struct {
+		threshold int
+		duration  time.Duration
+		factor    int
+		max       time.Duration
+	}
+)
+
+var cleanupInterval = time.Minute
+
+func NewRateLimiter(rateLimiterConfig *ConfigInput) *RateLimiter {
+	r := RateLimiter{
+		config:         rateLimiterConfig.Config,
+		prometheusFire: rateLimiterConfig.PrometheusFire,
+		logger:         rateLimiterConfig.Logger,
+
+		buckets:   make(map[string]*bucket),
+		offenders: make(map[string]*offender),
+	}
+
+	r.configure(r.config)
+
+	return &r
+}
+
+func (r *RateLimiter) configure(config *ttutils.ConfigRoot) {
+	r.rate = 0
+	r.burst = 0
+	r.ban = nil
+
+	if config.Space.RateLimit == nil {
+		return
+	}
+
+	r.rate = ttutils.Float64Value(config.Space.RateLimit.Rate)
+	r.burst = ttutils.IntValue(config.Space.RateLimit.Burst)
+
+	if ban := config.Space.RateLimit.Ban; ban != nil {
+		r.ban = &banPolicy{
+			threshold: ttutils.IntValue(ban.Threshold),
+			duration:  time.Duration(ttutils.IntValue(ban.Duration)) * time.Second,
+			factor:    ttutils.IntValue(ban.Factor),
+			max:       time.Duration(ttutils.IntValue(ban.Max)) * time.Second,
+		}
+	}
+}
+
+// Reset applies the new limits, the buckets and the bans are kept.
+func (r *RateLimiter) Reset(newConfig *ttutils.ConfigRoot) (*RateLimiter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger.
+		Debugf("reloading...")
+
+	r.config = newConfig
+	r.configure(newConfig)
+
+	r.logger.
+		Debugf("reloading... done!")
+
+	return r, nil
+}
+
+func (r *RateLimiter) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger.
+		Infof("starting...")
+
+	listenCtx, listenCancelCtx := context.WithCancel(context.Background())
+	r.context = listenCtx
+	r.contextCancel = listenCancelCtx
+
+	r.isInitialized = true
+
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-listenCtx.Done():
+				return
+			case <-ticker.C:
+				r.cleanup()
+			}
+		}
+	}()
+
+	r.logger.
+		Infof("starting... done!")
+}
+
+func (r *RateLimiter) Shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isInitialized {
+		r.logger.
+			Infof("stopping...")
+
+		r.contextCancel()
+		r.isInitialized = false
+
+		r.logger.
+			Infof("stopping... done!")
+	}
+}
+
+// Allow tells if a new connection from the ip can be served.
+func (r *RateLimiter) Allow(ip string) bool {
+	return r.allow(ip, "", r.rate, r.burst)
+}
+
+// AllowRoute tells if a query of the route from the ip can be served.
+func (r *RateLimiter) AllowRoute(ip string, route string, routeConfig *ttutils.RouteRateLimitConfig) bool {
+	if routeConfig == nil {
+		return true
+	}
+
+	return r.allow(ip, route, ttutils.Float64Value(routeConfig.Rate), ttutils.IntValue(routeConfig.Burst))
+}
+
+func (r *RateLimiter) allow(ip string, route string, rate float64, burst int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	if o, ok := r.offenders[ip]; ok && now.Before(o.bannedUntil) {
+		r.fireRejected(route, "banned")
+		return false
+	}
+
+	if rate <= 0 {
+		return true
+	}
+
+	key := ip + "|" + route
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		r.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+
+		return true
+	}
+
+	r.fireRejected(route, "limited")
+	r.violation(ip, now)
+
+	return false
+}
+
+// violation records a rejection of the ip and bans it when the threshold is reached,
+// the lock must be held.
+func (r *RateLimiter) violation(ip string, now time.Time) {
+	if r.ban == nil {
+		return
+	}
+
+	o, ok := r.offenders[ip]
+	if !ok {
+		o = &offender{}
+		r.offenders[ip] = o
+	}
+
+	o.violations++
+	o.lastSeen = now
+
+	if o.violations < r.ban.threshold {
+		return
+	}
+
+	// each new ban lasts longer than the previous one
+	duration := float64(r.ban.duration) * math.Pow(float64(r.ban.factor), float64(o.bans))
+	if duration > float64(r.ban.max) {
+		duration = float64(r.ban.max)
+	}
+
+	o.violations = 0
+	o.bans++
+	o.bannedUntil = now.Add(time.Duration(duration))
+
+	r.logger.
+		Warnf("banning %s for %s (ban #%d)", ip, time.Duration(duration), o.bans)
+
+	r.fireBanned()
+}
+
+// IsBanned tells if the ip is currently banned.
+func (r *RateLimiter) IsBanned(ip string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.offenders[ip]
+
+	return ok && time.Now().Before(o.bannedUntil)
+}
+
+// Bans returns the current bans, sorted by IP.
+func (r *RateLimiter) Bans() []Ban {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	var bans []Ban
+	for ip, o := range r.offenders {
+		if now.Before(o.bannedUntil) {
+			bans = append(bans, Ban{IP: ip, Count: o.bans, Until: o.bannedUntil})
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].IP < bans[j].IP
+	})
+
+	return bans
+}
+
+// cleanup forgets the full buckets and the offenders that have behaved
+// for the maximum ban duration.
+func (r *RateLimiter) cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	for key, b := range r.buckets {
+		if now.After(b.full) {
+			delete(r.buckets, key)
+		}
+	}
+
+	var forget time.Duration
+	if r.ban != nil {
+		forget = r.ban.max
+	}
+
+	for ip, o := range r.offenders {
+		if now.After(o.bannedUntil) && now.Sub(o.lastSeen) > forget && now.Sub(o.bannedUntil) > forget {
+			delete(r.offenders, ip)
+		}
+	}
+
+	r.fireBanned()
+}
+
+// fireRejected must be called with the lock held.
+func (r *RateLimiter) fireRejected(route string, reason string) {
+	if err := r.prometheusFire(&ttprom.PrometheusMetric{
+		Metric: ttprom.PrometheusRateLimitRejectedCounter,
+		Labels: []string{route, reason},
+		Action: "inc",
+	}); err != nil {
+		r.logger.
+			Errorf("firing prometheus failed -> %s", err)
+	}
+}
+
+// fireBanned must be called with the lock held.
+func (r *RateLimiter) fireBanned() {
+	now := time.Now()
+
+	banned := 0
+	for _, o := range r.offenders {
+		if now.Before(o.bannedUntil) {
+			banned++
+		}
+	}
+
+	if err := r.prometheusFire(&ttprom.PrometheusMetric{
+		Metric: ttprom.PrometheusRateLimitBannedGauge,
+		Labels: []string{},
+		Action: "set",
+		Values: float64(banned),
+	}); err != nil {
+		r.logger.
+			Errorf("firing prometheus failed -> %s", err)
+	}
+}
@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+func newTestRateLimiter(rateLimitConfig *ttutils.RateLimitConfig) *RateLimiter {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return NewRateLimiter(&ConfigInput{
+		Config: &ttutils.ConfigRoot{
+			Space: &ttutils.SpaceConfig{RateLimit: rateLimitConfig},
+		},
+		PrometheusFire: func(*ttprom.PrometheusMetric) error { return nil },
+		Logger:         logrus.NewEntry(logger),
+	})
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	r := newTestRateLimiter(nil)
+
+	for i := 0; i < 100; i++ {
+		if !r.Allow("192.0.2.1") {
+			t.Fatalf("connection #%d rejected without limits", i)
+		}
+	}
+
+	if !r.AllowRoute("192.0.2.1", "index", nil) {
+		t.Errorf("route rejected without limits")
+	}
+}
+
+func TestRateLimiterBucket(t *testing.T) {
+	r := newTestRateLimiter(&ttutils.RateLimitConfig{
+		Rate:  ttutils.Float64(1),
+		Burst: ttutils.Int(3),
+	})
+
+	for i := 0; i < 3; i++ {
+		if !r.Allow("192.0.2.1") {
+			t.Fatalf("connection #%d rejected within the burst", i)
+		}
+	}
+
+	if r.Allow("192.0.2.1") {
+		t.Errorf("connection allowed beyond the burst")
+	}
+
+	// each ip has its own bucket
+	if !r.Allow("192.0.2.2") {
+		t.Errorf("another ip is rejected")
+	}
+
+	// the bucket is refilled with the time
+	r.buckets["192.0.2.1|"].last = time.Now().Add(-2 * time.Second)
+
+	for i := 0; i < 2; i++ {
+		if !r.Allow("192.0.2.1") {
+			t.Errorf("connection #%d rejected after the refill", i)
+		}
+	}
+
+	if r.Allow("192.0.2.1") {
+		t.Errorf("connection allowed beyond the refill")
+	}
+
+	if r.IsBanned("192.0.2.1") {
+		t.Errorf("ip banned without ban policy")
+	}
+}
+
+func TestRateLimiterRoute(t *testing.T) {
+	r := newTestRateLimiter(nil)
+
+	routeConfig := &ttutils.RouteRateLimitConfig{Rate: ttutils.Float64(1), Burst: ttutils.Int(1)}
+
+	if !r.AllowRoute("192.0.2.1", "index", routeConfig) {
+		t.Errorf("first query rejected")
+	}
+
+	if r.AllowRoute("192.0.2.1", "index", routeConfig) {
+		t.Errorf("second query allowed")
+	}
+
+	// each route has its own bucket
+	if !r.AllowRoute("192.0.2.1", "about", routeConfig) {
+		t.Errorf("query of another route rejected")
+	}
+}
+
+func TestRateLimiterBanEscalation(t *testing.T) {
+	r := newTestRateLimiter(&ttutils.RateLimitConfig{
+		Rate:  ttutils.Float64(0.001),
+		Burst: ttutils.Int(1),
+		Ban: &ttutils.RateLimitBanConfig{
+			Threshold: ttutils.Int(2),
+			Duration:  ttutils.Int(10),
+			Factor:    ttutils.Int(2),
+			Max:       ttutils.Int(30),
+		},
+	})
+
+	const ip = "192.0.2.1"
+
+	if !r.Allow(ip) {
+		t.Fatalf("first connection rejected")
+	}
+
+	for _, wanted := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		// threshold violations
+		r.Allow(ip)
+
+		if r.IsBanned(ip) {
+			t.Fatalf("ip banned before the threshold")
+		}
+
+		start := time.Now()
+		r.Allow(ip)
+
+		if !r.IsBanned(ip) {
+			t.Fatalf("ip not banned at the threshold")
+		}
+
+		got := r.offenders[ip].bannedUntil.Sub(start)
+		if got < wanted-time.Second || got > wanted+time.Second {
+			t.Errorf("banned for %s, wanted %s", got, wanted)
+		}
+
+		if r.Allow(ip) {
+			t.Errorf("banned ip allowed")
+		}
+
+		bans := r.Bans()
+		if len(bans) != 1 || bans[0].IP != ip {
+			t.Fatalf("unexpected bans: %v", bans)
+		}
+
+		// the ban expires
+		r.offenders[ip].bannedUntil = time.Now().Add(-time.Second)
+
+		if r.IsBanned(ip) {
+			t.Fatalf("ip still banned after the expiration")
+		}
+	}
+
+	if got := r.offenders[ip].bans; got != 4 {
+		t.Errorf("%d bans, wanted 4", got)
+	}
+}
@@ -1,17 +1,36 @@
 package tcplistener
 
 import (
-	"os"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/caddyserver/certmagic"
-	"github.com/libdns/cloudflare"
-	"github.com/libdns/digitalocean"
-	"github.com/libdns/gandi"
-	"github.com/libdns/hetzner"
+	"github.com/sirupsen/logrus"
+	ttacmedns "github.com/tristan-weil/ttserver/svc/acmedns"
 	ttutils "github.com/tristan-weil/ttserver/utils"
 )
 
-func (t *TCPListener) configureACME() error {
+type (
+	// acmeHTTPResponder answers the http-01 challenges, its handler is swapped by the reloads.
+	acmeHTTPResponder struct {
+		address string
+		server  *http.Server
+		handler http.Handler
+
+		mu sync.RWMutex
+	}
+)
+
+// configureACME fills the state with the ACME cache and the HTTP responder,
+// they are released by the caller if an error is returned.
+func (t *TCPListener) configureACME(state *tlsState) (*tls.Config, error) {
 	t.logger.
 		Info("using a Let's Encrypt certificate")
 
@@ -36,50 +55,168 @@ func (t *TCPListener) configureACME() error {
 		},
 	})
 
+	state.acmeCache = leCache
+
 	leConfig := certmagic.New(leCache, leConfigOpts)
 
-	var dnsprovider interface{}
-	a := ttutils.StringValue(t.tlsConfig.ACME.DNSProvider)
-	switch a {
-	case "gandi":
-		dnsprovider = &gandi.Provider{
-			APIToken: os.Getenv("GANDI_API_TOKEN"),
+	acmeManagerOpts := certmagic.ACMEManager{
+		CA:                      ttutils.StringValue(t.tlsConfig.ACME.CA),
+		TestCA:                  ttutils.StringValue(t.tlsConfig.ACME.TestCA),
+		Email:                   ttutils.StringValue(t.tlsConfig.ACME.Email),
+		Agreed:                  true,
+		DisableHTTPChallenge:    true,
+		DisableTLSALPNChallenge: true,
+	}
+
+	if ttutils.NotStringEmpty(t.tlsConfig.ACME.TrustedRoots) {
+		roots, err := ioutil.ReadFile(ttutils.StringValue(t.tlsConfig.ACME.TrustedRoots))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ACME trusted roots: %s", err)
 		}
-	case "cloudflare":
-		dnsprovider = &cloudflare.Provider{
-			APIToken: os.Getenv("CLOUDFLARE_API_TOKEN"),
+
+		acmeManagerOpts.TrustedRoots = x509.NewCertPool()
+		if !acmeManagerOpts.TrustedRoots.AppendCertsFromPEM(roots) {
+			return nil, fmt.Errorf("unable to find any certificate in ACME trusted roots %s", ttutils.StringValue(t.tlsConfig.ACME.TrustedRoots))
 		}
-	case "hetzner":
-		dnsprovider = &hetzner.Provider{
-			AuthAPIToken: os.Getenv("HETZNER_API_TOKEN"),
+	}
+
+	// the dns-01 challenge has precedence over the others
+	if ttutils.StringSliceContains(t.tlsConfig.ACME.Challenges, "dns-01") {
+		dnsprovider, err := ttacmedns.New(ttutils.StringValue(t.tlsConfig.ACME.DNSProvider), t.tlsConfig.ACME.DNSParameters)
+		if err != nil {
+			return nil, err
 		}
-	case "digitalocean":
-		dnsprovider = &digitalocean.Provider{
-			APIToken: os.Getenv("DO_API_TOKEN"),
+
+		acmeManagerOpts.DNS01Solver = &certmagic.DNS01Solver{
+			DNSProvider: dnsprovider,
+			Resolvers:   t.tlsConfig.ACME.DNSResolvers,
 		}
 	}
 
-	acmeManager := certmagic.NewACMEManager(leConfig, certmagic.ACMEManager{
-		CA:     ttutils.StringValue(t.tlsConfig.ACME.CA),
-		TestCA: ttutils.StringValue(t.tlsConfig.ACME.TestCA),
-		Email:  ttutils.StringValue(t.tlsConfig.ACME.Email),
-		Agreed: true,
-		DNS01Solver: &certmagic.DNS01Solver{
-			DNSProvider: dnsprovider.(certmagic.ACMEDNSProvider),
-		},
-	})
+	// the tls-alpn-01 challenge is answered by the listener itself
+	if ttutils.StringSliceContains(t.tlsConfig.ACME.Challenges, "tls-alpn-01") {
+		host, port, err := net.SplitHostPort(t.address)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the port of the listener: %s", err)
+		}
+
+		acmeManagerOpts.DisableTLSALPNChallenge = false
+		acmeManagerOpts.ListenHost = host
+		acmeManagerOpts.AltTLSALPNPort, _ = strconv.Atoi(port)
+	}
+
+	acmeManager := certmagic.NewACMEManager(leConfig, acmeManagerOpts)
+
+	// the http-01 challenge is answered by a small HTTP responder
+	if ttutils.StringSliceContains(t.tlsConfig.ACME.Challenges, "http-01") {
+		if err := t.prepareACMEHTTPResponder(state, acmeManager); err != nil {
+			return nil, err
+		}
+	}
 
 	leConfig.Issuer = acmeManager
 
-	if err := leConfig.ManageSync(t.domains); err != nil {
-		return err
+	// the listener is not serving yet and can't answer the tls-alpn-01 challenge
+	if !acmeManager.DisableTLSALPNChallenge && acmeManager.DNS01Solver == nil {
+		t.logger.
+			Info("the Let's Encrypt certificate will be obtained in the background")
+
+		if err := leConfig.ManageAsync(context.Background(), t.domains); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := leConfig.ManageSync(t.domains); err != nil {
+			return nil, err
+		}
 	}
 
 	t.logger.
 		Debug("creating/checking the Let's Encrypt certificate... done!")
 
-	// TODO: replace
-	t.listener.tlsConfig = leConfig.TLSConfig()
+	return leConfig.TLSConfig(), nil
+}
+
+// prepareACMEHTTPResponder starts a responder for the state. On a reload, the current responder is kept
+// if its address doesn't change and answers the challenges of both configurations until the state is applied.
+func (t *TCPListener) prepareACMEHTTPResponder(state *tlsState, acmeManager *certmagic.ACMEManager) error {
+	address := ttutils.StringValue(t.tlsConfig.ACME.HTTP.Address)
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("unable to get the port of the ACME HTTP responder: %s", err)
+	}
+
+	// certmagic won't try to bind the port used by the responder
+	acmeManager.DisableHTTPChallenge = false
+	acmeManager.AltHTTPPort, _ = strconv.Atoi(port)
+
+	state.acmeHTTPHandler = acmeManager.HTTPChallengeHandler(http.NotFoundHandler())
+
+	if t.tlsState != nil && t.tlsState.acmeHTTP != nil && t.tlsState.acmeHTTP.address == address {
+		r := t.tlsState.acmeHTTP
+
+		state.acmeHTTP = r
+		state.acmeHTTPPrevious = r.getHandler()
+
+		r.setHandler(acmeManager.HTTPChallengeHandler(state.acmeHTTPPrevious))
+
+		return nil
+	}
+
+	ln, err := ttutils.Listen(address)
+	if err != nil {
+		return fmt.Errorf("unable to start the ACME HTTP responder: %s", err)
+	}
+
+	r := &acmeHTTPResponder{
+		address: address,
+		handler: state.acmeHTTPHandler,
+	}
+
+	r.server = &http.Server{
+		Handler:      r,
+		ReadTimeout:  t.timeouts.Read,
+		WriteTimeout: t.timeouts.Write,
+	}
+
+	state.acmeHTTP = r
+
+	t.logger.
+		Infof("ACME HTTP responder listening on %s", address)
+
+	go func() {
+		if err := r.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			t.logger.
+				Errorf("ACME HTTP responder failed -> %s", err)
+		}
+	}()
 
 	return nil
 }
+
+func (r *acmeHTTPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.getHandler().ServeHTTP(w, req)
+}
+
+func (r *acmeHTTPResponder) getHandler() http.Handler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.handler
+}
+
+func (r *acmeHTTPResponder) setHandler(handler http.Handler) {
+	r.mu.Lock()
+	r.handler = handler
+	r.mu.Unlock()
+}
+
+func (r *acmeHTTPResponder) stop(logger *logrus.Entry) {
+	ctxShutdown, ctxShutdownCancel := context.WithTimeout(context.Background(), shutdownServerTimeout)
+	defer ctxShutdownCancel()
+
+	if err := r.server.Shutdown(ctxShutdown); err != nil {
+		logger.
+			Errorf("unable to stop the ACME HTTP responder, %s", err)
+	}
+}
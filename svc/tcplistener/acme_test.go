@@ -0,0 +1,122 @@
+package tcplistener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+func getenvDefault(key string, value string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return value
+}
+
+// TestACMEPebble needs a Pebble server (https://github.com/letsencrypt/pebble): its directory url is given
+// by TTSERVER_TEST_PEBBLE (ex: https://127.0.0.1:14000/dir) and the CA bundle of its API by TTSERVER_TEST_PEBBLE_ROOTS
+// (ex: pebble.minica.pem). The HTTP responder listens on TTSERVER_TEST_PEBBLE_HTTP (127.0.0.1:5002 by default,
+// the httpPort of Pebble) and the domain is TTSERVER_TEST_PEBBLE_DOMAIN (localhost by default).
+// The certificate is obtained with the http-01 challenge, then the configuration is reloaded.
+func TestACMEPebble(t *testing.T) {
+	ca := os.Getenv("TTSERVER_TEST_PEBBLE")
+	if ca == "" {
+		t.Skip("TTSERVER_TEST_PEBBLE is not set")
+	}
+
+	httpAddress := getenvDefault("TTSERVER_TEST_PEBBLE_HTTP", "127.0.0.1:5002")
+	domain := getenvDefault("TTSERVER_TEST_PEBBLE_DOMAIN", "localhost")
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	l := &TCPListener{
+		address: "127.0.0.1:0",
+		domains: []string{domain},
+		tlsConfig: &ttutils.TLSConfig{
+			ACME: &ttutils.TLSACMEConfig{
+				Challenges:   []string{"http-01"},
+				HTTP:         &ttutils.TLSACMEHTTPConfig{Address: ttutils.String(httpAddress)},
+				Email:        ttutils.String("ttserver@example.org"),
+				CA:           ttutils.String(ca),
+				TestCA:       ttutils.String(ca),
+				TrustedRoots: ttutils.String(os.Getenv("TTSERVER_TEST_PEBBLE_ROOTS")),
+				Storage: &ttutils.TLSACMEStorageConfig{
+					File: &ttutils.TLSACMEFileStorageConfig{Path: ttutils.String(t.TempDir())},
+				},
+			},
+		},
+		logger: logrus.NewEntry(logger),
+	}
+
+	checkCertificate := func(tlsConfig *tls.Config) {
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+		if err != nil {
+			t.Fatalf("no certificate: %s", err)
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("unable to parse the certificate: %s", err)
+		}
+
+		if err := leaf.VerifyHostname(domain); err != nil {
+			t.Errorf("unexpected certificate: %s", err)
+		}
+	}
+
+	checkResponder := func() {
+		resp, err := http.Get("http://" + httpAddress + "/.well-known/acme-challenge/unknown")
+		if err != nil {
+			t.Fatalf("the ACME HTTP responder doesn't answer: %s", err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("the ACME HTTP responder answers %d", resp.StatusCode)
+		}
+	}
+
+	tlsConfig, state, err := l.configureTLS()
+	if err != nil {
+		t.Fatalf("unable to obtain the certificate: %s", err)
+	}
+
+	l.applyTLS(state)
+	defer func() { l.tlsState.release(nil, l.logger) }()
+
+	checkCertificate(tlsConfig)
+	checkResponder()
+
+	// a reload that fails keeps the responder of the current state
+	_, discarded, err := l.configureTLS()
+	if err != nil {
+		t.Fatalf("unable to reload: %s", err)
+	}
+
+	if discarded.acmeHTTP != state.acmeHTTP {
+		t.Errorf("the ACME HTTP responder isn't shared by the reload")
+	}
+
+	l.discardTLS(discarded)
+	checkResponder()
+
+	// a reload that succeeds uses the certificate from the storage
+	tlsConfig, state, err = l.configureTLS()
+	if err != nil {
+		t.Fatalf("unable to reload: %s", err)
+	}
+
+	l.applyTLS(state)
+
+	checkCertificate(tlsConfig)
+	checkResponder()
+}
@@ -0,0 +1,93 @@
+package tcplistener
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+// configureClientAuth asks the clients for a certificate,
+// the CA bundle (if any) is kept in the ClientCAs of the tls.Config.
+func (t *TCPListener) configureClientAuth(tlsConfig *tls.Config) error {
+	clientAuth := t.tlsConfig.ClientAuth
+
+	t.logger.
+		Debugf("configuring client authentication (%s)...", ttutils.StringValue(clientAuth.Mode))
+
+	var clientCAs *x509.CertPool
+
+	if ttutils.NotStringEmpty(clientAuth.CA) {
+		caBundle, err := ioutil.ReadFile(ttutils.StringValue(clientAuth.CA))
+		if err != nil {
+			return fmt.Errorf("unable to read client authentication CA bundle: %s", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBundle) {
+			return fmt.Errorf("unable to find any certificate in client authentication CA bundle %s", ttutils.StringValue(clientAuth.CA))
+		}
+	}
+
+	required := ttutils.StringValue(clientAuth.Mode) == "required"
+
+	// self-signed certificates (TOFU) can't be checked by the TLS stack,
+	// the verification against the CA (if any) is done after the handshake
+	switch {
+	case ttutils.BoolValue(clientAuth.AcceptSelfSigned) && required:
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	case ttutils.BoolValue(clientAuth.AcceptSelfSigned):
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	case required:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	tlsConfig.ClientCAs = clientCAs
+
+	t.logger.
+		Debugf("configuring client authentication (%s)... done!", ttutils.StringValue(clientAuth.Mode))
+
+	return nil
+}
+
+// clientCertificate returns the identity of the peer, if it has sent a certificate.
+func (t *TCPListener) clientCertificate(state tls.ConnectionState, clientCAs *x509.CertPool) *ttconn.ClientCertificate {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	clientCert := &ttconn.ClientCertificate{
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Verified:    len(state.VerifiedChains) > 0,
+	}
+
+	if !clientCert.Verified && clientCAs != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range state.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         clientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		clientCert.Verified = err == nil
+	}
+
+	return clientCert
+}
@@ -0,0 +1,185 @@
+package tcplistener
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// connLimiter bounds the number of connections being served,
+	// globally and per remote IP (0 means no limit).
+	connLimiter struct {
+		maxConns      int
+		maxConnsPerIP int
+		queue         bool
+
+		total int
+		perIP map[string]int
+
+		// admitted are the connections accepted and not ended yet,
+		// whatever they are doing (served, queued, refused...)
+		admitted int
+
+		waiting      int
+		waitingPerIP map[string]int
+
+		// closed and replaced each time a connection is released
+		released chan struct{}
+
+		mu sync.Mutex
+	}
+)
+
+const (
+	limitReasonMaxConnections      = "maxconnections"
+	limitReasonMaxConnectionsPerIP = "maxconnectionsperip"
+)
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{
+		perIP:        make(map[string]int),
+		waitingPerIP: make(map[string]int),
+		released:     make(chan struct{}),
+	}
+}
+
+func (l *connLimiter) setLimits(maxConns int, maxConnsPerIP int, queue bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxConns = maxConns
+	l.maxConnsPerIP = maxConnsPerIP
+	l.queue = queue
+
+	l.notify()
+}
+
+// admit is called for each accepted connection, before a goroutine is started for it:
+// at most maxConns connections can be served and as many can wait in the queue.
+// The others are rejected right away.
+func (l *connLimiter) admit() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxConns > 0 {
+		max := l.maxConns
+		if l.queue {
+			max *= 2
+		}
+
+		if l.admitted >= max {
+			return false
+		}
+	}
+
+	l.admitted++
+
+	return true
+}
+
+// leave is called when an admitted connection ends.
+func (l *connLimiter) leave() {
+	l.mu.Lock()
+	l.admitted--
+	l.mu.Unlock()
+}
+
+// acquire reserves a slot for the ip, waiting at most queueTimeout for one
+// to be released. It returns the reason of the refusal, if any.
+func (l *connLimiter) acquire(ip string, queueTimeout time.Duration) (reason string, queued bool) {
+	var timeout <-chan time.Time
+
+	for {
+		l.mu.Lock()
+
+		reason = l.exceeded(ip)
+		if reason == "" {
+			l.total++
+			l.perIP[ip]++
+
+			if queued {
+				l.dequeue(ip)
+			}
+
+			l.mu.Unlock()
+
+			return "", queued
+		}
+
+		if !queued {
+			// the queue can't hold more connections than the limits
+			if queueTimeout <= 0 ||
+				(l.maxConns > 0 && l.waiting >= l.maxConns) ||
+				(l.maxConnsPerIP > 0 && l.waitingPerIP[ip] >= l.maxConnsPerIP) {
+				l.mu.Unlock()
+
+				return reason, false
+			}
+
+			queued = true
+			l.waiting++
+			l.waitingPerIP[ip]++
+
+			timer := time.NewTimer(queueTimeout)
+			defer timer.Stop()
+
+			timeout = timer.C
+		}
+
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-timeout:
+			l.mu.Lock()
+			l.dequeue(ip)
+			l.mu.Unlock()
+
+			return reason, true
+		}
+	}
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	l.perIP[ip]--
+
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+
+	l.notify()
+}
+
+// dequeue must be called with the lock held.
+func (l *connLimiter) dequeue(ip string) {
+	l.waiting--
+	l.waitingPerIP[ip]--
+
+	if l.waitingPerIP[ip] <= 0 {
+		delete(l.waitingPerIP, ip)
+	}
+}
+
+// exceeded must be called with the lock held.
+func (l *connLimiter) exceeded(ip string) string {
+	if l.maxConns > 0 && l.total >= l.maxConns {
+		return limitReasonMaxConnections
+	}
+
+	if l.maxConnsPerIP > 0 && l.perIP[ip] >= l.maxConnsPerIP {
+		return limitReasonMaxConnectionsPerIP
+	}
+
+	return ""
+}
+
+// notify wakes up the queued connections, it must be called with the lock held.
+func (l *connLimiter) notify() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
@@ -0,0 +1,222 @@
+package tcplistener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	proxyprotocol "github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+type (
+	// proxyProtocolConn is a connection that may start with a PROXY protocol header,
+	// the header is kept if it has been used.
+	proxyProtocolConn struct {
+		net.Conn
+
+		header *proxyprotocol.Header
+	}
+
+	// bufferedConn reads the bytes already peeked before the connection itself.
+	bufferedConn struct {
+		net.Conn
+
+		reader *bufio.Reader
+	}
+)
+
+// the unique ID type is not known by the library
+const proxyProtocolTypeUniqueID proxyprotocol.PP2Type = 0x05
+
+// proxyProtocolSniffTimeout is how long the rest of a signature is waited for,
+// a short query (like the empty gopher selector) is the beginning of a signature.
+var proxyProtocolSniffTimeout = 250 * time.Millisecond
+
+func (t *TCPListener) isProxyProtocolEnabled() bool {
+	return t.proxyProtocol == "v1" || t.proxyProtocol == "v2" || t.proxyProtocol == "enabled"
+}
+
+// acceptProxyProtocol reads the PROXY protocol header (if any) within the timeout
+// and applies the policy of the upstream: only the trusted upstreams can set the client address.
+func (t *TCPListener) acceptProxyProtocol(c net.Conn, policyConfig *ttutils.ListenerProxyProtocolPolicyConfig, timeout time.Duration) (*proxyProtocolConn, error) {
+	policy := proxyprotocol.USE
+
+	if !isProxyProtocolTrusted(c.RemoteAddr(), policyConfig) {
+		policy = proxyprotocol.REJECT
+		if ttutils.StringValue(policyConfig.Untrusted) == "ignore" {
+			policy = proxyprotocol.IGNORE
+		}
+	} else if ttutils.StringValue(policyConfig.V1) == "require" || ttutils.StringValue(policyConfig.V2) == "require" {
+		policy = proxyprotocol.REQUIRE
+	}
+
+	if timeout > 0 {
+		if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := bufio.NewReader(c)
+
+	found, err := sniffProxyProtocol(c, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &proxyProtocolConn{Conn: &bufferedConn{Conn: c, reader: reader}}
+
+	if !found {
+		if policy == proxyprotocol.REQUIRE {
+			return nil, proxyprotocol.ErrNoProxyProtocol
+		}
+	} else {
+		ppConn := proxyprotocol.NewConn(
+			pc.Conn,
+			proxyprotocol.WithPolicy(policy),
+			proxyprotocol.ValidateHeader(func(header *proxyprotocol.Header) error {
+				versionPolicy := ttutils.StringValue(policyConfig.V1)
+				if header.Version == 2 {
+					versionPolicy = ttutils.StringValue(policyConfig.V2)
+				}
+
+				switch versionPolicy {
+				case "reject":
+					return fmt.Errorf("PROXY protocol v%d not allowed", header.Version)
+				case "ignore":
+					// a LOCAL header is read but its addresses are not used
+					header.Command = proxyprotocol.LOCAL
+				default:
+					pc.header = header
+				}
+
+				return nil
+			}),
+		)
+
+		// an empty read only triggers the parsing of the header
+		if _, err := ppConn.Read(nil); err != nil {
+			return nil, err
+		}
+
+		pc.Conn = ppConn
+	}
+
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+// sniffProxyProtocol tells if the connection starts with a PROXY protocol signature.
+func sniffProxyProtocol(c net.Conn, reader *bufio.Reader) (bool, error) {
+	for n := 1; ; n++ {
+		buf, err := reader.Peek(n)
+		if err != nil {
+			var netErr net.Error
+
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			} else if n > 1 && errors.As(err, &netErr) && netErr.Timeout() {
+				// the client is waiting for the response to its query
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		if !bytes.HasPrefix(proxyprotocol.SIGV1, buf) && !bytes.HasPrefix(proxyprotocol.SIGV2, buf) {
+			return false, nil
+		}
+
+		if bytes.Equal(buf, proxyprotocol.SIGV1) || bytes.Equal(buf, proxyprotocol.SIGV2) {
+			return true, nil
+		}
+
+		if n == 1 {
+			if err := c.SetReadDeadline(time.Now().Add(proxyProtocolSniffTimeout)); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// proxyInfo returns what the TLVs of the header tell about the client,
+// nil if there is nothing to tell.
+func proxyInfo(header *proxyprotocol.Header) (*ttconn.ProxyInfo, error) {
+	if header == nil || header.Version != 2 {
+		return nil, nil
+	}
+
+	tlvs, err := header.TLVs()
+	if err != nil || len(tlvs) == 0 {
+		return nil, err
+	}
+
+	info := &ttconn.ProxyInfo{}
+
+	for _, tlv := range tlvs {
+		switch tlv.Type {
+		case proxyprotocol.PP2_TYPE_AUTHORITY:
+			// the host name sent by the client (SNI)
+			info.SNI = string(tlv.Value)
+		case proxyprotocol.PP2_TYPE_ALPN:
+			info.ALPN = string(tlv.Value)
+		case proxyProtocolTypeUniqueID:
+			info.UniqueID = string(tlv.Value)
+		case proxyprotocol.PP2_TYPE_SSL:
+			if tlv.Length < 5 {
+				return nil, proxyprotocol.ErrMalformedTLV
+			}
+
+			info.SSL = tlv.Value[0]&tlvparse.PP2_BITFIELD_CLIENT_SSL != 0
+			info.SSLClientVerified = tlv.Value[0]&(tlvparse.PP2_BITFIELD_CLIENT_CERT_CONN|tlvparse.PP2_BITFIELD_CLIENT_CERT_SESS) != 0 &&
+				binary.BigEndian.Uint32(tlv.Value[1:5]) == 0
+
+			subTLVs, err := proxyprotocol.SplitTLVs(tlv.Value[5:])
+			if err != nil {
+				return nil, err
+			}
+
+			for _, subTLV := range subTLVs {
+				switch subTLV.Type {
+				case proxyprotocol.PP2_SUBTYPE_SSL_VERSION:
+					info.SSLVersion = string(subTLV.Value)
+				case proxyprotocol.PP2_SUBTYPE_SSL_CIPHER:
+					info.SSLCipher = string(subTLV.Value)
+				case proxyprotocol.PP2_SUBTYPE_SSL_CN:
+					info.SSLClientCN = string(subTLV.Value)
+				}
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func isProxyProtocolTrusted(addr net.Addr, policyConfig *ttutils.ListenerProxyProtocolPolicyConfig) bool {
+	ip := net.ParseIP(ttconn.RemoteIP(addr))
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range policyConfig.TrustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
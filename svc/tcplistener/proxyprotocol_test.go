@@ -0,0 +1,266 @@
+package tcplistener
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	proxyprotocol "github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+func TestSniffProxyProtocol(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		wanted bool
+	}{
+		{"v1", "PROXY TCP4 192.0.2.1 192.0.2.2 1234 70\r\n", true},
+		{"v2", string(proxyprotocol.SIGV2) + "\x21\x11\x00\x0c", true},
+		{"selector", "blog/2020\r\n", false},
+		{"empty selector", "\r\n", false},
+		{"short query", "PRO", false},
+		{"no query", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+
+			data := tt.data
+
+			go func() {
+				_, _ = client.Write([]byte(data))
+
+				// the client waits for the response, unless it has sent nothing
+				if data == "" {
+					client.Close()
+				}
+			}()
+			defer client.Close()
+
+			reader := bufio.NewReader(server)
+
+			found, err := sniffProxyProtocol(server, reader)
+			if err != nil {
+				t.Fatalf("unable to sniff: %s", err)
+			}
+
+			if found != tt.wanted {
+				t.Errorf("sniffProxyProtocol() = %t, wanted %t", found, tt.wanted)
+			}
+
+			// the data are kept for the handler
+			if buffered, _ := reader.Peek(reader.Buffered()); string(buffered) != tt.data {
+				t.Errorf("buffered %q, wanted %q", buffered, tt.data)
+			}
+		})
+	}
+}
+
+func TestProxyInfo(t *testing.T) {
+	ssl := func(flags byte, verify byte, subTLVs []proxyprotocol.TLV) proxyprotocol.TLV {
+		raw, err := proxyprotocol.JoinTLVs(subTLVs)
+		if err != nil {
+			t.Fatalf("unable to join the sub TLVs: %s", err)
+		}
+
+		value := append([]byte{flags, 0, 0, 0, verify}, raw...)
+
+		return proxyprotocol.TLV{Type: proxyprotocol.PP2_TYPE_SSL, Length: len(value), Value: value}
+	}
+
+	tlv := func(tlvType proxyprotocol.PP2Type, value string) proxyprotocol.TLV {
+		return proxyprotocol.TLV{Type: tlvType, Length: len(value), Value: []byte(value)}
+	}
+
+	tests := []struct {
+		name   string
+		tlvs   []proxyprotocol.TLV
+		wanted *ttconn.ProxyInfo
+		err    bool
+	}{
+		{"no tlv", nil, nil, false},
+		{
+			"authority, alpn and unique id",
+			[]proxyprotocol.TLV{
+				tlv(proxyprotocol.PP2_TYPE_AUTHORITY, "example.org"),
+				tlv(proxyprotocol.PP2_TYPE_ALPN, "gopher"),
+				tlv(proxyProtocolTypeUniqueID, "1234"),
+			},
+			&ttconn.ProxyInfo{SNI: "example.org", ALPN: "gopher", UniqueID: "1234"},
+			false,
+		},
+		{
+			"ssl with a verified client certificate",
+			[]proxyprotocol.TLV{
+				ssl(tlvparse.PP2_BITFIELD_CLIENT_SSL|tlvparse.PP2_BITFIELD_CLIENT_CERT_CONN, 0, []proxyprotocol.TLV{
+					tlv(proxyprotocol.PP2_SUBTYPE_SSL_VERSION, "TLSv1.3"),
+					tlv(proxyprotocol.PP2_SUBTYPE_SSL_CIPHER, "TLS_AES_128_GCM_SHA256"),
+					tlv(proxyprotocol.PP2_SUBTYPE_SSL_CN, "client"),
+				}),
+			},
+			&ttconn.ProxyInfo{
+				SSL: true, SSLVersion: "TLSv1.3", SSLCipher: "TLS_AES_128_GCM_SHA256",
+				SSLClientCN: "client", SSLClientVerified: true,
+			},
+			false,
+		},
+		{
+			"ssl with an unverified client certificate",
+			[]proxyprotocol.TLV{
+				ssl(tlvparse.PP2_BITFIELD_CLIENT_SSL|tlvparse.PP2_BITFIELD_CLIENT_CERT_CONN, 1, nil),
+			},
+			&ttconn.ProxyInfo{SSL: true},
+			false,
+		},
+		{
+			"ssl without client certificate",
+			[]proxyprotocol.TLV{ssl(tlvparse.PP2_BITFIELD_CLIENT_SSL, 0, nil)},
+			&ttconn.ProxyInfo{SSL: true},
+			false,
+		},
+		{"truncated ssl", []proxyprotocol.TLV{tlv(proxyprotocol.PP2_TYPE_SSL, "\x01")}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := proxyprotocol.HeaderProxyFromAddrs(2,
+				&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+				&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 70})
+
+			if err := header.SetTLVs(tt.tlvs); err != nil {
+				t.Fatalf("unable to set the TLVs: %s", err)
+			}
+
+			info, err := proxyInfo(header)
+			if (err != nil) != tt.err {
+				t.Fatalf("proxyInfo() error = %v, wanted an error: %t", err, tt.err)
+			}
+
+			if diff := cmp.Diff(tt.wanted, info); diff != "" {
+				t.Errorf("unexpected info (-wanted +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProxyInfoV1(t *testing.T) {
+	header := proxyprotocol.HeaderProxyFromAddrs(1,
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 70})
+
+	if info, err := proxyInfo(header); info != nil || err != nil {
+		t.Errorf("proxyInfo() = %v, %v, wanted nothing", info, err)
+	}
+}
+
+func TestIsProxyProtocolTrusted(t *testing.T) {
+	var trusted []*net.IPNet
+
+	for _, cidr := range []string{"127.0.0.0/8", "::1/128"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		trusted = append(trusted, n)
+	}
+
+	tests := []struct {
+		policy *ttutils.ListenerProxyProtocolPolicyConfig
+		ip     string
+		wanted bool
+	}{
+		{&ttutils.ListenerProxyProtocolPolicyConfig{TrustedNets: trusted}, "127.0.0.1", true},
+		{&ttutils.ListenerProxyProtocolPolicyConfig{TrustedNets: trusted}, "::1", true},
+		{&ttutils.ListenerProxyProtocolPolicyConfig{TrustedNets: trusted}, "192.0.2.1", false},
+		{&ttutils.ListenerProxyProtocolPolicyConfig{}, "127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		addr := &net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 1234}
+
+		if got := isProxyProtocolTrusted(addr, tt.policy); got != tt.wanted {
+			t.Errorf("isProxyProtocolTrusted(%s, %d nets) = %t, wanted %t", tt.ip, len(tt.policy.TrustedNets), got, tt.wanted)
+		}
+	}
+}
+
+func TestAcceptProxyProtocolPolicy(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("127.0.0.0/8")
+
+	v2Header, err := proxyprotocol.HeaderProxyFromAddrs(2,
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 70}).Format()
+	if err != nil {
+		t.Fatalf("unable to format the v2 header: %s", err)
+	}
+
+	// the empty gopher selector follows the header
+	headers := map[string]string{
+		"none": "\r\n",
+		"v1":   "PROXY TCP4 192.0.2.1 192.0.2.2 1234 70\r\n\r\n",
+		"v2":   string(v2Header) + "\r\n",
+	}
+
+	// the wanted remote address for no header, a v1 header and a v2 header ("" when the connection is refused)
+	tests := []struct {
+		v1     string
+		v2     string
+		wanted map[string]string
+	}{
+		{"use", "use", map[string]string{"none": "127.0.0.1", "v1": "192.0.2.1", "v2": "192.0.2.1"}},
+		{"use", "reject", map[string]string{"none": "127.0.0.1", "v1": "192.0.2.1", "v2": ""}},
+		{"ignore", "use", map[string]string{"none": "127.0.0.1", "v1": "127.0.0.1", "v2": "192.0.2.1"}},
+		{"require", "require", map[string]string{"none": "", "v1": "192.0.2.1", "v2": "192.0.2.1"}},
+		{"require", "reject", map[string]string{"none": "", "v1": "192.0.2.1", "v2": ""}},
+		{"reject", "require", map[string]string{"none": "", "v1": "", "v2": "192.0.2.1"}},
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer ln.Close()
+
+	for _, tt := range tests {
+		for name, header := range headers {
+			t.Run(tt.v1+" "+tt.v2+" "+name, func(t *testing.T) {
+				client, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					t.Fatalf("unable to connect: %s", err)
+				}
+				defer client.Close()
+
+				if _, err := client.Write([]byte(header)); err != nil {
+					t.Fatalf("unable to write: %s", err)
+				}
+
+				c, err := ln.Accept()
+				if err != nil {
+					t.Fatalf("unable to accept: %s", err)
+				}
+				defer c.Close()
+
+				policy := &ttutils.ListenerProxyProtocolPolicyConfig{
+					V1:          ttutils.String(tt.v1),
+					V2:          ttutils.String(tt.v2),
+					TrustedNets: []*net.IPNet{trusted},
+				}
+
+				pc, err := new(TCPListener).acceptProxyProtocol(c, policy, time.Second)
+
+				got := ""
+				if err == nil {
+					got = pc.RemoteAddr().(*net.TCPAddr).IP.String()
+				}
+
+				if got != tt.wanted[name] {
+					t.Errorf("remote address %q (error: %v), wanted %q", got, err, tt.wanted[name])
+				}
+			})
+		}
+	}
+}
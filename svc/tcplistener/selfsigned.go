@@ -0,0 +1,286 @@
+package tcplistener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+type (
+	selfSignedCertificate struct {
+		certFile string
+		keyFile  string
+		domains  []string
+		validity time.Duration
+		renew    bool
+
+		cert *tls.Certificate
+
+		// done stops the renewal
+		done chan struct{}
+
+		mu sync.RWMutex
+	}
+)
+
+var (
+	// the certificate is renewed the day before it expires,
+	// or in the last quarter of its validity if it's shorter
+	selfSignedRenewBefore = 24 * time.Hour
+	selfSignedRenewCheck  = time.Hour
+)
+
+// configureSelfSigned fills the state with the certificate, it's renewed once the state is applied.
+func (t *TCPListener) configureSelfSigned(state *tlsState) (*tls.Config, error) {
+	t.logger.
+		Info("using a self-signed certificate")
+
+	t.logger.
+		Debug("creating/checking the self-signed certificate...")
+
+	path := ttutils.StringValue(t.tlsConfig.SelfSigned.Path)
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the self-signed certificate directory: %s", err)
+	}
+
+	s := &selfSignedCertificate{
+		certFile: filepath.Join(path, "cert.pem"),
+		keyFile:  filepath.Join(path, "key.pem"),
+		domains:  t.domains,
+		validity: time.Duration(ttutils.IntValue(t.tlsConfig.SelfSigned.Validity)) * 24 * time.Hour,
+		renew:    ttutils.BoolValue(t.tlsConfig.SelfSigned.Renew),
+		done:     make(chan struct{}),
+	}
+
+	if err := s.load(); err != nil {
+		t.logger.
+			Infof("generating a new self-signed certificate: %s", err)
+
+		s.mu.Lock()
+		err = s.generate()
+		s.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.logger.
+		Infof("self-signed certificate fingerprint (SHA-256): %s, expires on %s", s.fingerprint(), s.notAfter())
+
+	t.logger.
+		Debug("creating/checking the self-signed certificate... done!")
+
+	state.selfSigned = s
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+
+			return s.cert, nil
+		},
+	}, nil
+}
+
+// load reads the persisted certificate, it fails if the certificate
+// doesn't match the domains or is expired.
+func (s *selfSignedCertificate) load() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	cert.Leaf = leaf
+
+	var names []string
+	names = append(names, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	if !cmp.Equal(names, selfSignedNames(s.domains)) {
+		return fmt.Errorf("the domains have changed")
+	}
+
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("the certificate expired on %s", leaf.NotAfter)
+	}
+
+	s.mu.Lock()
+	s.cert = &cert
+	s.mu.Unlock()
+
+	return nil
+}
+
+// renewSelfSigned checks the certificate periodically until it's stopped,
+// the handshakes always get the current certificate.
+func (t *TCPListener) renewSelfSigned(s *selfSignedCertificate) {
+	ticker := time.NewTicker(selfSignedRenewCheck)
+	defer ticker.Stop()
+
+	for {
+		if renewed, err := s.renewIfExpiring(); err != nil {
+			t.logger.
+				Errorf("unable to renew the self-signed certificate -> %s", err)
+		} else if renewed {
+			t.logger.
+				Infof("self-signed certificate renewed, fingerprint (SHA-256): %s, expires on %s", s.fingerprint(), s.notAfter())
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *selfSignedCertificate) renewIfExpiring() (bool, error) {
+	s.mu.RLock()
+	notAfter := s.cert.Leaf.NotAfter
+	s.mu.RUnlock()
+
+	if time.Now().Add(s.renewBefore()).Before(notAfter) {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return true, s.generate()
+}
+
+// renewBefore is the window before the expiration in which the certificate is renewed,
+// a new certificate is never in it.
+func (s *selfSignedCertificate) renewBefore() time.Duration {
+	if quarter := s.validity / 4; quarter < selfSignedRenewBefore {
+		return quarter
+	}
+
+	return selfSignedRenewBefore
+}
+
+// generate creates and persists a new certificate, the lock must be held.
+func (s *selfSignedCertificate) generate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("unable to generate a private key: %s", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("unable to generate a serial number: %s", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ttserver"}, CommonName: s.domains[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(s.validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, d := range s.domains {
+		if ip := net.ParseIP(d); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, d)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("unable to create the certificate: %s", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("unable to marshal the private key: %s", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	if err := ioutil.WriteFile(s.keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("unable to write the private key: %s", err)
+	}
+
+	if err := ioutil.WriteFile(s.certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("unable to write the certificate: %s", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(der); err != nil {
+		return err
+	}
+
+	s.cert = &cert
+
+	return nil
+}
+
+func (s *selfSignedCertificate) fingerprint() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sum := sha256.Sum256(s.cert.Leaf.Raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *selfSignedCertificate) notAfter() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cert.Leaf.NotAfter
+}
+
+// selfSignedNames returns the names in the order used by the certificate:
+// the DNS names first, then the IPs.
+func selfSignedNames(domains []string) []string {
+	var dnsNames, ips []string
+
+	for _, d := range domains {
+		if ip := net.ParseIP(d); ip != nil {
+			ips = append(ips, ip.String())
+		} else {
+			dnsNames = append(dnsNames, d)
+		}
+	}
+
+	return append(dnsNames, ips...)
+}
@@ -0,0 +1,160 @@
+package tcplistener
+
+import (
+	"bytes"
+	"math/rand"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+// tarpitDetectors tell if the first line of a connection comes from a scanner.
+var tarpitDetectors = map[string]func(line []byte) bool{
+	"http": func(line []byte) bool {
+		for _, verb := range []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"} {
+			if bytes.HasPrefix(line, []byte(verb+" ")) {
+				return true
+			}
+		}
+
+		return false
+	},
+	// a TLS handshake record
+	"tls": func(line []byte) bool {
+		return len(line) >= 2 && line[0] == 0x16 && line[1] == 0x03
+	},
+	"binary": func(line []byte) bool {
+		if !utf8.Valid(line) {
+			return true
+		}
+
+		for _, b := range line {
+			if (b < 0x20 && b != '\t') || b == 0x7f {
+				return true
+			}
+		}
+
+		return false
+	},
+}
+
+// tarpitReason peeks the first line of a connection, without consuming it,
+// and tells why it has to be sent to the tarpit (empty if it doesn't).
+func (t *TCPListener) tarpitReason(conn *ttconn.Connection, tarpit *ttutils.ListenerTarpitConfig) string {
+	if len(tarpit.Match) == 0 && len(tarpit.PatternsRegexp) == 0 {
+		return ""
+	}
+
+	// the handler gets the error (if any) when reading the query
+	if err := conn.SetReadTimeout(conn.Timeouts.Read); err != nil {
+		return ""
+	}
+
+	if _, err := conn.Reader.Peek(1); err != nil {
+		return ""
+	}
+
+	line, _ := conn.Reader.Peek(conn.Reader.Buffered())
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+
+	for _, match := range tarpit.Match {
+		if tarpitDetectors[match](line) {
+			return match
+		}
+	}
+
+	for _, pattern := range tarpit.PatternsRegexp {
+		if pattern.Match(line) {
+			return "pattern"
+		}
+	}
+
+	return ""
+}
+
+// serveTarpit holds the connection open and drip-feeds it a byte at each interval,
+// until the client gives up, the duration is over or the listener is shut down.
+func (t *TCPListener) serveTarpit(conn *ttconn.Connection, tarpit *ttutils.ListenerTarpitConfig, reason string) {
+	if atomic.AddInt32(&t.tarpitted, 1) > int32(ttutils.IntValue(tarpit.MaxConnections)) {
+		atomic.AddInt32(&t.tarpitted, -1)
+
+		conn.Logger.
+			Infof("tarpit full, connection closed (%s)", reason)
+
+		t.fireTarpitMetric(conn, reason, "dropped")
+
+		return
+	}
+
+	defer func() {
+		t.fireTarpitActiveMetric(conn, atomic.AddInt32(&t.tarpitted, -1))
+	}()
+
+	t.fireTarpitMetric(conn, reason, "held")
+	t.fireTarpitActiveMetric(conn, atomic.LoadInt32(&t.tarpitted))
+
+	conn.Logger.
+		Infof("connection sent to the tarpit (%s)", reason)
+
+	interval := time.Duration(ttutils.IntValue(tarpit.Interval)) * time.Second
+	timer := time.NewTimer(time.Duration(ttutils.IntValue(tarpit.Duration)) * time.Second)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.InitialConn.SetWriteDeadline(time.Now().Add(interval)); err != nil {
+				return
+			}
+
+			if _, err := conn.InitialConn.Write([]byte{byte('a' + rand.Intn(26))}); err != nil {
+				conn.Logger.
+					Debugf("tarpit left by the client after %s", time.Since(conn.Start))
+
+				return
+			}
+		case <-timer.C:
+			conn.Logger.
+				Debugf("tarpit released after %s", time.Since(conn.Start))
+
+			return
+		case <-t.tarpitDone:
+			return
+		}
+	}
+}
+
+func (t *TCPListener) fireTarpitMetric(conn *ttconn.Connection, reason string, outcome string) {
+	if err := t.prometheusFire(&ttprom.PrometheusMetric{
+		Metric: ttprom.PrometheusTarpitConnCounter,
+		Labels: []string{reason, outcome},
+		Action: "inc",
+	}); err != nil {
+		t.logger.
+			WithField("connection", conn.UUID).
+			Errorf("firing prometheus failed -> %s", err)
+	}
+}
+
+func (t *TCPListener) fireTarpitActiveMetric(conn *ttconn.Connection, tarpitted int32) {
+	if err := t.prometheusFire(&ttprom.PrometheusMetric{
+		Metric: ttprom.PrometheusTarpitActiveConnGauge,
+		Labels: []string{},
+		Action: "set",
+		Values: float64(tarpitted),
+	}); err != nil {
+		t.logger.
+			WithField("connection", conn.UUID).
+			Errorf("firing prometheus failed -> %s", err)
+	}
+}
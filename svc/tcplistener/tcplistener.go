@@ -1,27 +1,34 @@
 package tcplistener
 
 import (
-	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
+	"github.com/caddyserver/certmagic"
 	"github.com/google/go-cmp/cmp"
+	"github.com/mholt/acmez"
 	proxyprotocol "github.com/pires/go-proxyproto"
 	"github.com/sirupsen/logrus"
 	ttconn "github.com/tristan-weil/ttserver/server/connection"
 	tthandler "github.com/tristan-weil/ttserver/server/handler"
 	ttcache "github.com/tristan-weil/ttserver/svc/cache"
+	ttgeoip "github.com/tristan-weil/ttserver/svc/geoip"
 	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttratelimit "github.com/tristan-weil/ttserver/svc/ratelimit"
 	ttutils "github.com/tristan-weil/ttserver/utils"
 )
 
 type (
 	TCPListener struct {
-		config *ttutils.ConfigRoot
-		cache  func() ttcache.ICacheCache
+		config      *ttutils.ConfigRoot
+		cache       func() ttcache.ICacheCache
+		fetchCache  func() *ttcache.Fetch
+		rateLimiter func() *ttratelimit.RateLimiter
+		geoIP       func() *ttgeoip.GeoIP
 
 		address       string
 		domains       []string
@@ -31,58 +38,95 @@ type (
 		serveConnHandler tthandler.IServeConnHandler
 		prometheusFire   func(*ttprom.PrometheusMetric) error
 
-		// ReadTimeout is the maximum duration before timing out reads of the
-		// response. This sets a deadline on the connection and isn't a handler
-		// timeout.
-		readTimeout time.Duration
-
-		// WriteTimeout is the maximum duration before timing out writes of the
-		// response. This sets a deadline on the connection and isn't a handler
-		// timeout.
-		writeTimeout time.Duration
+		// Timeouts are the maximum durations of the handshake, the read of the query,
+		// its processing and the write of the response.
+		timeouts ttconn.Timeouts
 
 		// MaxQueryBytes is the maximum amount of bytes that will be read from
 		// the connection to determine the query.
 		maxQueryBytes int
 
+		// limiter bounds the connections being served, when a limit is hit they wait
+		// at most queueTimeout (0 means the busy response is sent immediately).
+		limiter      *connLimiter
+		queueTimeout time.Duration
+
+		// drainTimeout is how long the connections being served can end by themselves
+		// when the listener is shut down (0 means no limit), they are closed after it.
+		drainTimeout time.Duration
+		drained      int
+
+		// tarpit holds the connections of the scanners, at most tarpit.MaxConnections at the same time
+		// (tarpitted), until tarpitDone is closed by the shutdown.
+		tarpit     *ttutils.ListenerTarpitConfig
+		tarpitted  int32
+		tarpitDone chan struct{}
+
 		logger *logrus.Entry
 
+		// listener is swapped under muListener when the address or the TLS configuration change
 		listener   *netListenerWrapper
 		activeConn map[net.Conn]*ttconn.Connection
 
-		shuttingDown                ttutils.AtomicBool
-		alreadyNewTLSGetCertificate ttutils.AtomicBool
+		// tlsState keeps the tls.Config of the listener working
+		tlsState *tlsState
+
+		shuttingDown ttutils.AtomicBool
 
 		muActiveConn sync.RWMutex
+		muListener   sync.RWMutex
 	}
 
 	TCPListenerConfigInput struct {
 		Config           *ttutils.ConfigRoot
 		Cache            func() ttcache.ICacheCache
+		FetchCache       func() *ttcache.Fetch
+		RateLimiter      func() *ttratelimit.RateLimiter
+		GeoIP            func() *ttgeoip.GeoIP
 		ServeConnHandler tthandler.IServeConnHandler
 		PrometheusFire   func(*ttprom.PrometheusMetric) error
 		Logger           *logrus.Entry
 	}
 
 	netListenerWrapper struct {
-		netListener           net.Listener
-		proxyProtocolListener *proxyprotocol.Listener
+		netListener net.Listener
 
 		tlsConfig *tls.Config
 	}
+
+	// tlsState is what a tls.Config needs besides itself: the cache maintaining the ACME certificates,
+	// the ACME HTTP responder and the self-signed certificate to renew.
+	// It's built on the side by a reload and only applied if the reload succeeds.
+	tlsState struct {
+		acmeCache  *certmagic.Cache
+		acmeHTTP   *acmeHTTPResponder
+		selfSigned *selfSignedCertificate
+
+		// acmeHTTPHandler is the handler of the responder once the state is applied,
+		// acmeHTTPPrevious is restored if the state is discarded and the responder is shared
+		acmeHTTPHandler  http.Handler
+		acmeHTTPPrevious http.Handler
+	}
 )
 
 var (
 	shutdownActiConnPollInterval = 500 * time.Millisecond
 	shutdownServerTimeout        = 5 * time.Second
+	defaultMaxQueryBytes         = 512
+	busyWriteTimeout             = 250 * time.Millisecond
 )
 
 func NewTCPListener(serverConfig *TCPListenerConfigInput) *TCPListener {
 	t := TCPListener{
 		config:           serverConfig.Config,
 		cache:            serverConfig.Cache,
+		fetchCache:       serverConfig.FetchCache,
+		rateLimiter:      serverConfig.RateLimiter,
+		geoIP:            serverConfig.GeoIP,
 		serveConnHandler: serverConfig.ServeConnHandler,
 		prometheusFire:   serverConfig.PrometheusFire,
+		limiter:          newConnLimiter(),
+		tarpitDone:       make(chan struct{}),
 
 		logger: serverConfig.Logger,
 	}
@@ -97,13 +141,45 @@ func (t *TCPListener) Initialize() {
 		t.proxyProtocol = ttutils.StringValue(t.config.Space.Listener.ProxyProtocol)
 		t.tlsConfig = t.config.Space.Listener.TLSConfig
 
-		// TODO: by config
-		t.readTimeout = 1 * time.Minute
-		t.writeTimeout = 1 * time.Minute
-		t.maxQueryBytes = 512
+		t.initializeLimits(t.config)
 	}
 }
 
+// initializeLimits reads the timeouts, the maximum size of the queries and
+// the connections limits, they only apply to new connections.
+func (t *TCPListener) initializeLimits(config *ttutils.ConfigRoot) {
+	timeouts := config.Space.Listener.Timeouts
+
+	t.timeouts = ttconn.Timeouts{
+		Handshake: time.Duration(ttutils.IntValue(timeouts.Handshake)) * time.Second,
+		Read:      time.Duration(ttutils.IntValue(timeouts.Read)) * time.Second,
+		Process:   time.Duration(ttutils.IntValue(timeouts.Process)) * time.Second,
+		Write:     time.Duration(ttutils.IntValue(timeouts.Write)) * time.Second,
+	}
+
+	t.drainTimeout = time.Duration(ttutils.IntValue(timeouts.Drain)) * time.Second
+
+	t.maxQueryBytes = defaultMaxQueryBytes
+	if h, ok := t.serveConnHandler.(tthandler.IMaxQueryBytesHandler); ok && h.MaxQueryBytes() > 0 {
+		t.maxQueryBytes = h.MaxQueryBytes()
+	}
+
+	if config.Space.Listener.MaxQueryBytes != nil {
+		t.maxQueryBytes = ttutils.IntValue(config.Space.Listener.MaxQueryBytes)
+	}
+
+	limits := config.Space.Listener.Limits
+
+	t.queueTimeout = 0
+	if ttutils.StringValue(limits.OnLimit) == "queue" {
+		t.queueTimeout = time.Duration(ttutils.IntValue(limits.QueueTimeout)) * time.Second
+	}
+
+	t.limiter.setLimits(ttutils.IntValue(limits.MaxConnections), ttutils.IntValue(limits.MaxConnectionsPerIP), t.queueTimeout > 0)
+
+	t.tarpit = config.Space.Listener.Tarpit
+}
+
 // Listen listens on the TCP network address s.Addr and then
 // calls serve to handle incoming connections.
 func (t *TCPListener) Listen() error {
@@ -111,100 +187,254 @@ func (t *TCPListener) Listen() error {
 		t.logger.
 			Debugf("creating...")
 
-		listenStr := ""
+		ln, err := ttutils.Listen(t.address)
+		if err != nil {
+			return err
+		}
 
-		ln, err := net.Listen("tcp", t.address)
+		tlsConfig, state, err := t.configureTLS()
 		if err != nil {
+			ln.Close()
 			return err
 		}
 
-		t.listener = &netListenerWrapper{netListener: ln}
+		t.applyTLS(state)
 
-		if t.proxyProtocol == "v1" || t.proxyProtocol == "v2" || t.proxyProtocol == "enabled" {
-			listenStr += fmt.Sprintf(" + PROXY protocol (%s)", t.proxyProtocol)
-		}
+		t.listener = &netListenerWrapper{netListener: ln, tlsConfig: tlsConfig}
 
-		if t.tlsConfig != nil {
-			if t.tlsConfig.ACME == nil {
-				logrus.Warn("no tls configuration configured")
-			} else {
-				if t.tlsConfig.ACME != nil {
-					if err := t.configureACME(); err != nil {
-						return err
-					}
-				}
+		t.logListening()
 
-				listenStr += " + tls"
+		t.logger.
+			Debugf("creating... done!")
+	}
 
-				if !t.alreadyNewTLSGetCertificate.IsSet() {
-					oldGetCertificate := t.listener.tlsConfig.GetCertificate
+	return nil
+}
 
-					t.muActiveConn.Lock()
-					defer t.muActiveConn.Unlock()
+// configureTLS creates the tls.Config used by the handshakes, nil without TLS, and its state.
+// The current state is left untouched, the new one must be applied or discarded if an error is returned.
+func (t *TCPListener) configureTLS() (*tls.Config, *tlsState, error) {
+	state := &tlsState{}
 
-					t.alreadyNewTLSGetCertificate.SetTrue()
+	if t.tlsConfig == nil {
+		return nil, state, nil
+	}
 
-					t.listener.tlsConfig.GetCertificate = func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
-						sni := info.ServerName
+	var tlsConfig *tls.Config
+	var err error
+
+	if t.tlsConfig.ACME != nil {
+		tlsConfig, err = t.configureACME(state)
+	} else if t.tlsConfig.SelfSigned != nil {
+		tlsConfig, err = t.configureSelfSigned(state)
+	}
+
+	if err != nil {
+		t.discardTLS(state)
+		return nil, nil, err
+	}
+
+	if tlsConfig == nil {
+		logrus.Warn("no tls configuration configured")
+		return nil, state, nil
+	}
 
-						t.muActiveConn.RLock()
-						conn := t.activeConn[info.Conn]
-						t.muActiveConn.RUnlock()
+	if t.tlsConfig.ClientAuth != nil {
+		if err := t.configureClientAuth(tlsConfig); err != nil {
+			t.discardTLS(state)
+			return nil, nil, err
+		}
+	}
+
+	// the SNI is kept by the connection if it's one of the domains
+	domains := t.domains
+	getCertificate := tlsConfig.GetCertificate
+
+	tlsConfig.GetCertificate = func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		sni := info.ServerName
 
-						if conn != nil {
-							for _, d := range t.domains {
-								if d == sni {
-									conn.SNI = sni
+		t.muActiveConn.RLock()
+		conn := t.activeConn[info.Conn]
+		t.muActiveConn.RUnlock()
 
-									t.logger.
-										WithField("connection", conn.UUID).
-										Tracef("SNI found for %q", sni)
+		if conn != nil {
+			for _, d := range domains {
+				if d == sni {
+					conn.SNI = sni
 
-									break
-								}
-							}
-						}
+					t.logger.
+						WithField("connection", conn.UUID).
+						Tracef("SNI found for %q", sni)
 
-						return oldGetCertificate(info)
-					}
+					break
 				}
 			}
 		}
 
-		if t.proxyProtocol == "v1" || t.proxyProtocol == "v2" || t.proxyProtocol == "enabled" {
-			t.listener.proxyProtocolListener = &proxyprotocol.Listener{Listener: ln}
-		}
+		return getCertificate(info)
+	}
 
-		t.logger.
-			Infof("listening%s...", listenStr)
+	return tlsConfig, state, nil
+}
 
-		t.logger.
-			Debugf("creating... done!")
+// applyTLS makes the state the current one,
+// what the previous state used and the new one doesn't is released.
+func (t *TCPListener) applyTLS(state *tlsState) {
+	previous := t.tlsState
+	t.tlsState = state
+
+	if state.acmeHTTP != nil {
+		state.acmeHTTP.setHandler(state.acmeHTTPHandler)
 	}
 
-	return nil
+	if state.selfSigned != nil && state.selfSigned.renew {
+		go t.renewSelfSigned(state.selfSigned)
+	}
+
+	if previous != nil {
+		previous.release(state.acmeHTTP, t.logger)
+	}
+}
+
+// discardTLS releases a state that won't be applied,
+// the responder shared with the current state only answers its challenges again.
+func (t *TCPListener) discardTLS(state *tlsState) {
+	if t.tlsState != nil && state.acmeHTTP != nil && state.acmeHTTP == t.tlsState.acmeHTTP {
+		state.acmeHTTP.setHandler(state.acmeHTTPPrevious)
+		state.release(state.acmeHTTP, t.logger)
+
+		return
+	}
+
+	state.release(nil, t.logger)
 }
 
+// release stops the ACME cache, the responder unless it's kept and the renewal of the self-signed certificate.
+func (s *tlsState) release(keep *acmeHTTPResponder, logger *logrus.Entry) {
+	if s.acmeCache != nil {
+		s.acmeCache.Stop()
+	}
+
+	if s.acmeHTTP != nil && s.acmeHTTP != keep {
+		s.acmeHTTP.stop(logger)
+	}
+
+	if s.selfSigned != nil {
+		close(s.selfSigned.done)
+	}
+}
+
+func (t *TCPListener) logListening() {
+	listenStr := ""
+
+	if t.isProxyProtocolEnabled() {
+		listenStr += fmt.Sprintf(" + PROXY protocol (%s)", t.proxyProtocol)
+	}
+
+	if t.listener.tlsConfig != nil {
+		listenStr += " + tls"
+
+		if t.tlsConfig.ClientAuth != nil {
+			listenStr += fmt.Sprintf(" + client certificates (%s)", ttutils.StringValue(t.tlsConfig.ClientAuth.Mode))
+		}
+	}
+
+	t.logger.
+		Infof("listening%s...", listenStr)
+}
+
+// Reset applies the new configuration property by property, the connections being served are kept:
+// a new address is bound before the old one is closed, a new tls.Config is used by the new handshakes
+// and the timeouts and the limits apply to the new connections.
 func (t *TCPListener) Reset(newConfig *ttutils.ConfigRoot) (*TCPListener, error) {
-	defer func() {
+	if !t.IsServing() {
 		t.config = newConfig
-	}()
+		return nil, nil
+	}
+
+	oldAddress, oldDomains, oldTLSConfig, oldProxyProtocol := t.address, t.domains, t.tlsConfig, t.proxyProtocol
 
-	if ttutils.StringValue(newConfig.Space.Listener.Address) != t.address ||
-		ttutils.StringValue(newConfig.Space.Listener.ProxyProtocol) != t.proxyProtocol ||
-		!cmp.Equal(newConfig.Space.Listener.Domains, t.domains) {
+	t.address = ttutils.StringValue(newConfig.Space.Listener.Address)
+	t.domains = newConfig.Space.Listener.Domains
+	t.tlsConfig = newConfig.Space.Listener.TLSConfig
+
+	revert := func() {
+		t.address, t.domains, t.tlsConfig = oldAddress, oldDomains, oldTLSConfig
+	}
 
+	var newListener net.Listener
+	if t.address != oldAddress {
 		t.logger.
-			Infof("reloading... stopping listener...")
+			Infof("reloading... listening on %s...", t.address)
 
-		if err := t.Shutdown(); err != nil {
-			return t, fmt.Errorf("unable to stop listener: %s", err)
+		ln, err := ttutils.Listen(t.address)
+		if err != nil {
+			revert()
+			return t, fmt.Errorf("unable to listen on %s: %s", t.address, err)
 		}
 
+		newListener = ln
+	}
+
+	t.muListener.RLock()
+	tlsConfig := t.listener.tlsConfig
+	t.muListener.RUnlock()
+
+	var newTLSState *tlsState
+
+	// the tls-alpn-01 challenge depends on the address
+	tlsChanged := !cmp.Equal(t.tlsConfig, oldTLSConfig) || !cmp.Equal(t.domains, oldDomains) ||
+		(t.address != oldAddress && t.tlsConfig != nil && t.tlsConfig.ACME != nil)
+
+	if tlsChanged {
 		t.logger.
-			Debugf("reloading... stopping listener... done!")
+			Infof("reloading... configuring tls...")
 
-		return nil, nil
+		newTLSConfig, state, err := t.configureTLS()
+		if err != nil {
+			revert()
+
+			if newListener != nil {
+				newListener.Close()
+			}
+
+			return t, fmt.Errorf("unable to configure tls: %s", err)
+		}
+
+		tlsConfig, newTLSState = newTLSConfig, state
+	}
+
+	t.muListener.Lock()
+	oldListener := t.listener.netListener
+	if newListener != nil {
+		t.listener.netListener = newListener
+	}
+	t.listener.tlsConfig = tlsConfig
+	if newListener != nil {
+		t.logger = t.logger.WithField("space-svc", "listener("+t.address+")")
+	}
+	t.proxyProtocol = ttutils.StringValue(newConfig.Space.Listener.ProxyProtocol)
+	t.initializeLimits(newConfig)
+	t.config = newConfig
+	t.muListener.Unlock()
+
+	// the previous certificates are not maintained anymore
+	if newTLSState != nil {
+		t.applyTLS(newTLSState)
+	}
+
+	if newListener != nil {
+		if err := oldListener.Close(); err != nil {
+			t.logger.
+				Errorf("unable to close %s, %s", oldAddress, err)
+		}
+
+		t.logger.
+			Infof("reloading... %s closed", oldAddress)
+	}
+
+	if newListener != nil || tlsChanged || t.proxyProtocol != oldProxyProtocol {
+		t.logListening()
 	}
 
 	return t, nil
@@ -224,30 +454,41 @@ func (t *TCPListener) Shutdown() error {
 
 	var err error
 
-	if t.listener.proxyProtocolListener != nil {
-		err = t.listener.proxyProtocolListener.Listener.Close()
-		t.listener.proxyProtocolListener = nil
-	} else {
-		err = t.listener.netListener.Close()
-		t.listener.netListener = nil
-	}
+	t.muListener.Lock()
+	err = t.listener.netListener.Close()
+	t.listener.netListener = nil
+	t.muListener.Unlock()
 
 	if err != nil {
 		t.logger.Infof("unable to close, %s", err)
 	}
 
+	// the tarpitted connections aren't drained
+	close(t.tarpitDone)
+
+	if t.tlsState != nil {
+		t.tlsState.release(nil, t.logger)
+		t.tlsState = nil
+	}
+
 	t.muActiveConn.RLock()
 	if len(t.activeConn) != 0 {
-		t.logger.Infof("waiting to end all connections")
+		t.logger.Infof("draining %d connections...", len(t.activeConn))
 	}
 	t.muActiveConn.RUnlock()
 
-	ctxShutdown, ctxShutdownCancel := context.WithTimeout(context.Background(), shutdownServerTimeout)
-	defer ctxShutdownCancel()
+	var drainTimeout <-chan time.Time
+	if t.drainTimeout > 0 {
+		timer := time.NewTimer(t.drainTimeout)
+		defer timer.Stop()
+
+		drainTimeout = timer.C
+	}
 
 	ticker := time.NewTicker(shutdownActiConnPollInterval)
 	defer ticker.Stop()
 
+DRAIN:
 	for {
 		t.muActiveConn.RLock()
 		if len(t.activeConn) == 0 {
@@ -257,13 +498,46 @@ func (t *TCPListener) Shutdown() error {
 		t.muActiveConn.RUnlock()
 
 		select {
-		case <-ctxShutdown.Done():
-			break
+		case <-drainTimeout:
+			break DRAIN
 		case <-ticker.C:
 		}
 	}
 
+	// the stragglers are cut
+	t.muActiveConn.Lock()
+	drained := t.drained
+	forced := len(t.activeConn)
+	for c := range t.activeConn {
+		c.Close()
+	}
 	t.activeConn = nil
+	t.muActiveConn.Unlock()
+
+	if forced > 0 {
+		t.logger.Warnf("%d connections closed after the drain timeout (%s)", forced, t.drainTimeout)
+	}
+
+	if drained > 0 {
+		t.logger.Infof("%d connections drained", drained)
+	}
+
+	for outcome, count := range map[string]int{"drained": drained, "forced": forced} {
+		if count == 0 {
+			continue
+		}
+
+		if err := t.prometheusFire(&ttprom.PrometheusMetric{
+			Metric: ttprom.PrometheusShutdownConnCounter,
+			Labels: []string{outcome},
+			Action: "add",
+			Values: float64(count),
+		}); err != nil {
+			t.logger.
+				Errorf("firing prometheus failed -> %s", err)
+		}
+	}
+
 	t.listener = nil
 
 	t.logger.
@@ -282,16 +556,17 @@ func (t *TCPListener) Serve() error {
 
 		var c net.Conn
 		var err error
-		var curConnection *ttconn.Connection
 
 		t.activeConn = make(map[net.Conn]*ttconn.Connection)
 
 		for {
 			// Accept
-			if t.listener.proxyProtocolListener != nil {
-				c, err = t.listener.proxyProtocolListener.Accept()
-			} else if t.listener.netListener != nil {
-				c, err = t.listener.netListener.Accept()
+			t.muListener.RLock()
+			ln := t.listener.netListener
+			t.muListener.RUnlock()
+
+			if ln != nil {
+				c, err = ln.Accept()
 			} else {
 				err = fmt.Errorf("no more listener available")
 			}
@@ -301,6 +576,15 @@ func (t *TCPListener) Serve() error {
 					return nil
 				}
 
+				// the listener has been replaced by a reload
+				t.muListener.RLock()
+				replaced := ln != nil && t.listener.netListener != ln
+				t.muListener.RUnlock()
+
+				if replaced {
+					continue
+				}
+
 				return err
 			}
 
@@ -310,69 +594,320 @@ func (t *TCPListener) Serve() error {
 				WithField("connection", uuidStr).
 				Debugf("connection accepted")
 
-			// config Connection
-			var t0 = time.Now()
-			if t.readTimeout > 0 {
-				c.SetReadDeadline(t0.Add(t.readTimeout))
+			// serving
+			t.muListener.RLock()
+			config, tlsConfig := t.config, t.listener.tlsConfig
+			t.muListener.RUnlock()
+
+			if !t.limiter.admit() {
+				t.serveBusy(c, uuidStr, config, tlsConfig)
+				continue
 			}
 
-			if t.writeTimeout > 0 {
-				c.SetWriteDeadline(t0.Add(t.writeTimeout))
+			go t.serveConn(c, uuidStr, config, tlsConfig)
+		}
+	}
+
+	return nil
+}
+
+// serveBusy answers a connection that can't be admitted, in the accept loop: the busy response
+// is sent within busyWriteTimeout and the query isn't read. With TLS, it's only closed.
+func (t *TCPListener) serveBusy(c net.Conn, uuidStr string, config *ttutils.ConfigRoot, tlsConfig *tls.Config) {
+	conn := ttconn.NewConnection(&ttconn.ConfigInput{
+		InitialConn: c,
+		CurConn:     c,
+		Timeouts:    ttconn.Timeouts{Write: busyWriteTimeout},
+		UUID:        uuidStr,
+
+		Config:         config,
+		PrometheusFire: t.prometheusFire,
+		Cache:          t.cache,
+		FetchCache:     t.fetchCache,
+		RateLimiter:    t.rateLimiter,
+		Logger:         t.logger,
+	})
+
+	defer conn.Close()
+
+	t.fireConnMetric(conn, ttprom.PrometheusConnRejectedCounter, limitReasonMaxConnections)
+
+	conn.Logger.
+		Infof("connection rejected, %s reached", limitReasonMaxConnections)
+
+	if tlsConfig != nil {
+		return
+	}
+
+	if err := t.serveConnHandler.ServeConnBusy(conn); err != nil {
+		conn.Logger.
+			Debugf("unable to send the busy response -> %s", err)
+	}
+
+	conn.Flush()
+}
+
+// serveConn checks the connection against the limits of the listener
+// before handing it over to the handler.
+func (t *TCPListener) serveConn(c net.Conn, uuidStr string, config *ttutils.ConfigRoot, tlsConfig *tls.Config) {
+	// the tarpitted connections have their own limit
+	admitted := true
+	leave := func() {
+		if admitted {
+			admitted = false
+			t.limiter.leave()
+		}
+	}
+
+	defer leave()
+
+	// the settings can be changed by a reload
+	t.muListener.RLock()
+	proxyProtocolEnabled := t.isProxyProtocolEnabled()
+	timeouts, maxQueryBytes, queueTimeout := t.timeouts, t.maxQueryBytes, t.queueTimeout
+	tarpit := t.tarpit
+	t.muListener.RUnlock()
+
+	var proxyHeader *proxyprotocol.Header
+
+	// the address of the client is known once the PROXY protocol header is read
+	if proxyProtocolEnabled {
+		pc, err := t.acceptProxyProtocol(c, config.Space.Listener.ProxyProtocolPolicy, timeouts.Handshake)
+		if err != nil {
+			t.logger.
+				WithField("connection", uuidStr).
+				Infof("PROXY protocol header refused -> %s", err)
+
+			c.Close()
+			return
+		}
+
+		proxyHeader = pc.header
+		c = pc
+	}
+
+	// config Connection
+	curConn := c
+
+	if tlsConfig != nil {
+		curConn = tls.Server(c, tlsConfig)
+	}
+
+	conn := ttconn.NewConnection(&ttconn.ConfigInput{
+		InitialConn:   c,
+		CurConn:       curConn,
+		MaxQueryBytes: int64(maxQueryBytes),
+		Timeouts:      timeouts,
+		UUID:          uuidStr,
+
+		Config:         config,
+		PrometheusFire: t.prometheusFire,
+		Cache:          t.cache,
+		FetchCache:     t.fetchCache,
+		RateLimiter:    t.rateLimiter,
+		Logger:         t.logger,
+	})
+
+	if info, err := proxyInfo(proxyHeader); err != nil {
+		conn.Logger.
+			Warnf("PROXY protocol TLVs ignored -> %s", err)
+	} else if info != nil {
+		conn.Proxy = info
+
+		fields := logrus.Fields{}
+		for field, value := range map[string]string{
+			"proxy-sni":      info.SNI,
+			"proxy-alpn":     info.ALPN,
+			"proxy-uniqueid": info.UniqueID,
+			"proxy-ssl":      info.SSLVersion,
+		} {
+			if value != "" {
+				fields[field] = value
 			}
+		}
+
+		conn.Logger = conn.Logger.WithFields(fields)
+	}
+
+	if geoIP := t.geoIP(); geoIP.IsEnabled() {
+		conn.GeoIP = geoIP.Lookup(conn.RemoteIP)
 
-			curConn := c
+		country := "unknown"
+		if conn.GeoIP != nil {
+			if conn.GeoIP.Country != "" {
+				country = conn.GeoIP.Country
 
-			if t.listener.tlsConfig != nil {
-				curConn = tls.Server(c, t.listener.tlsConfig)
+				conn.Logger = conn.Logger.WithField("country", conn.GeoIP.Country)
 			}
 
-			curConnection = ttconn.NewConnection(&ttconn.ConfigInput{
-				InitialConn:   c,
-				CurConn:       curConn,
-				MaxqueryBytes: int64(t.maxQueryBytes),
-				UUID:          uuidStr,
+			if conn.GeoIP.ASN != 0 {
+				conn.Logger = conn.Logger.WithField("asn", conn.GeoIP.ASN)
+			}
+		}
 
-				Config:         t.config,
-				PrometheusFire: t.prometheusFire,
-				Cache:          t.cache,
-				Logger:         t.logger,
-			})
+		t.fireConnMetric(conn, ttprom.PrometheusGeoIPConnCounter, country)
+	}
 
-			t.trackConn(curConnection, true)
+	t.trackConn(conn, true)
 
-			// serving
-			go func(conn *ttconn.Connection) {
-				defer func() {
-					t.logger.
-						WithField("connection", conn.UUID).
-						Debugf("end of connection")
+	defer func() {
+		t.logger.
+			WithField("connection", conn.UUID).
+			Debugf("end of connection")
 
-					conn.Flush()
-					conn.Close()
-					t.trackConn(conn, false)
-				}()
+		conn.Flush()
+		conn.Close()
+		t.trackConn(conn, false)
+	}()
 
-				if err := t.serveConnHandler.ServeConn(conn); err != nil {
-					t.logger.
-						WithField("connection", conn.UUID).
-						Errorf("%s", err)
+	if !conn.Config.Space.Listener.ACL.IsAllowed(conn.RemoteIP, conn.GeoIP) {
+		conn.Logger.
+			Infof("connection refused by the acl")
 
-					conn.Error = err
-				}
-			}(curConnection)
+		if t.handshake(conn, tlsConfig) {
+			if err := t.serveConnHandler.ServeConnError(conn, "403"); err != nil {
+				conn.Error = err
+			}
 		}
+
+		return
 	}
 
-	return nil
+	if rateLimiter := t.rateLimiter(); rateLimiter != nil && !rateLimiter.Allow(conn.RemoteIP) {
+		if tarpit != nil && ttutils.BoolValue(tarpit.Banned) && rateLimiter.IsBanned(conn.RemoteIP) {
+			leave()
+			t.serveTarpit(conn, tarpit, "banned")
+			return
+		}
+
+		conn.Logger.
+			Infof("connection rejected, rate limit reached")
+
+		if t.handshake(conn, tlsConfig) {
+			if err := t.serveConnHandler.ServeConnError(conn, "429"); err != nil {
+				conn.Error = err
+			}
+		}
+
+		return
+	}
+
+	// the first line can only be read without TLS
+	if tarpit != nil && tlsConfig == nil {
+		if reason := t.tarpitReason(conn, tarpit); reason != "" {
+			leave()
+			t.serveTarpit(conn, tarpit, reason)
+			return
+		}
+	}
+
+	reason, queued := t.limiter.acquire(conn.RemoteIP, queueTimeout)
+	if queued {
+		t.fireConnMetric(conn, ttprom.PrometheusConnQueuedCounter, reason)
+	}
+
+	if reason != "" {
+		t.fireConnMetric(conn, ttprom.PrometheusConnRejectedCounter, reason)
+
+		conn.Logger.
+			Infof("connection rejected, %s reached", reason)
+
+		if t.handshake(conn, tlsConfig) {
+			if err := t.serveConnHandler.ServeConnError(conn, "503"); err != nil {
+				conn.Error = err
+			}
+		}
+
+		return
+	}
+
+	defer t.limiter.release(conn.RemoteIP)
+
+	if !t.handshake(conn, tlsConfig) {
+		return
+	}
+
+	if err := t.serveConnHandler.ServeConn(conn); err != nil {
+		t.logger.
+			WithField("connection", conn.UUID).
+			Errorf("%s", err)
+
+		conn.Error = err
+	}
+}
+
+// handshake completes the TLS handshake (if any) of the connection,
+// it tells if the connection can be served.
+func (t *TCPListener) handshake(conn *ttconn.Connection, tlsConfig *tls.Config) bool {
+	if tlsConn, ok := conn.CurConn.(*tls.Conn); ok {
+		if conn.Timeouts.Handshake > 0 {
+			if err := conn.InitialConn.SetDeadline(time.Now().Add(conn.Timeouts.Handshake)); err != nil {
+				conn.Error = err
+				return false
+			}
+		}
+
+		if err := tlsConn.Handshake(); err != nil {
+			t.logger.
+				WithField("connection", conn.UUID).
+				Errorf("tls handshake failed -> %s", err)
+
+			conn.Error = err
+			return false
+		}
+
+		// a tls-alpn-01 challenge ends with the handshake
+		if tlsConn.ConnectionState().NegotiatedProtocol == acmez.ACMETLS1Protocol {
+			t.logger.
+				WithField("connection", conn.UUID).
+				Debugf("ACME tls-alpn-01 challenge answered")
+
+			return false
+		}
+
+		if err := conn.InitialConn.SetDeadline(time.Time{}); err != nil {
+			conn.Error = err
+			return false
+		}
+
+		conn.ClientCert = t.clientCertificate(tlsConn.ConnectionState(), tlsConfig.ClientCAs)
+		if conn.ClientCert != nil {
+			conn.Logger = conn.Logger.
+				WithField("clientcert", conn.ClientCert.Fingerprint)
+		}
+	}
+
+	return true
+}
+
+func (t *TCPListener) fireConnMetric(conn *ttconn.Connection, metric interface{}, label string) {
+	if err := t.prometheusFire(&ttprom.PrometheusMetric{
+		Metric: metric,
+		Labels: []string{label},
+		Action: "inc",
+	}); err != nil {
+		t.logger.
+			WithField("connection", conn.UUID).
+			Errorf("firing prometheus failed -> %s", err)
+	}
 }
 
 func (t *TCPListener) IsServing() bool {
+	t.muListener.RLock()
+	defer t.muListener.RUnlock()
+
 	return t.activeConn != nil && t.listener.netListener != nil
 }
 
 func (t *TCPListener) trackConn(conn *ttconn.Connection, add bool) {
 	t.muActiveConn.Lock()
 
+	// the listener has been shut down
+	if t.activeConn == nil {
+		t.muActiveConn.Unlock()
+		return
+	}
+
 	if add {
 		t.activeConn[conn.InitialConn] = conn
 		t.muActiveConn.Unlock()
@@ -390,6 +925,10 @@ func (t *TCPListener) trackConn(conn *ttconn.Connection, add bool) {
 	} else {
 		conn.End = time.Now()
 		delete(t.activeConn, conn.InitialConn)
+
+		if t.isShuttingDown() {
+			t.drained++
+		}
 		t.muActiveConn.Unlock()
 
 		if err := t.prometheusFire(&ttprom.PrometheusMetric{
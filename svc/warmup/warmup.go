@@ -0,0 +1,228 @@
+package warmup
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	ttconn "github.com/tristan-weil/ttserver/server/connection"
+	tthandler "github.com/tristan-weil/ttserver/server/handler"
+	ttcache "github.com/tristan-weil/ttserver/svc/cache"
+	ttprom "github.com/tristan-weil/ttserver/svc/prometheus"
+	ttutils "github.com/tristan-weil/ttserver/utils"
+)
+
+type (
+	// Warmup renders some routes in the background, as the cron does, to fill the cache
+	// before the first visitors.
+	Warmup struct {
+		config     *ttutils.ConfigRoot
+		cache      func() ttcache.ICacheCache
+		fetchCache func() *ttcache.Fetch
+
+		serveConnHandler tthandler.IServeConnHandler
+		prometheusFire   func(*ttprom.PrometheusMetric) error
+
+		logger        *logrus.Entry
+		context       context.Context
+		contextCancel context.CancelFunc
+		done          chan struct{}
+
+		mu            sync.Mutex
+		isInitialized bool
+	}
+
+	ConfigInput struct {
+		ServeConnHandler tthandler.IServeConnHandler
+		Config           *ttutils.ConfigRoot
+		Cache            func() ttcache.ICacheCache
+		FetchCache       func() *ttcache.Fetch
+		PrometheusFire   func(metric *ttprom.PrometheusMetric) error
+		Logger           *logrus.Entry
+	}
+)
+
+func NewWarmup(warmupConfig *ConfigInput) *Warmup {
+	w := Warmup{
+		config:           warmupConfig.Config,
+		cache:            warmupConfig.Cache,
+		fetchCache:       warmupConfig.FetchCache,
+		serveConnHandler: warmupConfig.ServeConnHandler,
+		prometheusFire:   warmupConfig.PrometheusFire,
+		logger:           warmupConfig.Logger,
+	}
+
+	// nothing to wait for until started
+	w.done = make(chan struct{})
+	close(w.done)
+
+	return &w
+}
+
+// Start starts the warm-up in the background.
+func (w *Warmup) Start() {
+	w.logger.
+		Infof("starting...")
+
+	w.mu.Lock()
+	w.isInitialized = true
+	w.mu.Unlock()
+
+	w.Run()
+
+	w.logger.
+		Infof("starting... done!")
+}
+
+// Run starts a new warm-up in the background, the one in progress stops rendering new routes.
+func (w *Warmup) Run() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.isInitialized {
+		return
+	}
+
+	if w.contextCancel != nil {
+		w.contextCancel()
+	}
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	w.context = ctx
+	w.contextCancel = ctxCancel
+
+	done := make(chan struct{})
+	w.done = done
+
+	go func() {
+		defer close(done)
+
+		w.run(ctx)
+	}()
+}
+
+func (w *Warmup) run(ctx context.Context) {
+	routes := w.routes()
+	startTime := time.Now()
+
+	w.logger.
+		Infof("warming up %d routes...", len(routes))
+
+	rendered := w.Render(ctx, routes, ttutils.IntValue(w.config.Space.Cache.Warmup.Concurrency))
+
+	w.logger.
+		Infof("warming up... done! (%d routes in %s)", rendered, time.Since(startTime).Round(time.Millisecond))
+}
+
+// Render renders the routes, as the cron does, and returns the number of routes rendered.
+// It doesn't need the warm-up to be started.
+func (w *Warmup) Render(ctx context.Context, routes []string, concurrency int) int {
+	var (
+		slots    = make(chan struct{}, concurrency)
+		wg       sync.WaitGroup
+		rendered int32
+	)
+
+LOOP:
+	for _, route := range routes {
+		select {
+		case <-ctx.Done():
+			w.logger.
+				Infof("rendering... interrupted!")
+
+			break LOOP
+		case slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+
+		go func(route string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			fakeConn := &ttconn.Connection{
+				Logger:         w.logger.WithField("connection", route+":warmup"),
+				PrometheusFire: w.prometheusFire,
+				Config:         w.config,
+				Cache:          w.cache,
+				FetchCache:     w.fetchCache,
+
+				Start:         time.Now(),
+				LocalAddress:  ttutils.StringValue(w.config.Space.Listener.Address),
+				RemoteAddress: ttutils.StringValue(w.config.Space.Listener.Address),
+				UUID:          route + ":warmup",
+				State:         ttconn.CONNECTION_STATUS_CRON,
+			}
+
+			err := w.serveConnHandler.ServeCrontab(fakeConn, route, nil)
+			count := atomic.AddInt32(&rendered, 1)
+
+			logger := w.logger.
+				WithField("route", route).
+				WithField("code", fakeConn.ReturnCode)
+
+			if err != nil {
+				logger.Errorf("rendering... %d/%d -> %s", count, len(routes), err)
+			} else {
+				logger.Debugf("rendering... %d/%d", count, len(routes))
+			}
+		}(route)
+	}
+
+	wg.Wait()
+
+	return int(atomic.LoadInt32(&rendered))
+}
+
+// routes returns the configured routes, or all the cron routes.
+func (w *Warmup) routes() []string {
+	if len(w.config.Space.Cache.Warmup.Routes) > 0 {
+		return w.config.Space.Cache.Warmup.Routes
+	}
+
+	var routes []string
+
+	for routeName, routeConf := range w.config.Space.Routes {
+		if routeConf.Cron != nil && routeName[0] != '~' {
+			routes = append(routes, routeName)
+		}
+	}
+
+	sort.Strings(routes)
+
+	return routes
+}
+
+// Wait waits for the end of the warm-up in progress.
+func (w *Warmup) Wait() {
+	w.mu.Lock()
+	done := w.done
+	w.mu.Unlock()
+
+	<-done
+}
+
+// Shutdown stops the warm-up, the routes being rendered are not interrupted.
+func (w *Warmup) Shutdown() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.isInitialized {
+		w.logger.
+			Infof("stopping...")
+
+		if w.contextCancel != nil {
+			w.contextCancel()
+		}
+
+		w.isInitialized = false
+
+		w.logger.
+			Infof("stopping... done!")
+	}
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"fmt"
+	"net"
 	"regexp"
+	"strings"
 	"sync"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
@@ -30,11 +33,56 @@ type (
 	//
 	CacheConfig struct {
 		Expiration *int               `json:"expiration,omitempty"`
+		Key        []string           `json:"key,omitempty"`
+		MaxRoutes  *int               `json:"maxroutes,omitempty"`
 		Memory     *CacheMemoryConfig `json:"memory,omitempty"`
+		Disk       *CacheDiskConfig   `json:"disk,omitempty"`
+		Redis      *CacheRedisConfig  `json:"redis,omitempty"`
+		Fetch      *CacheFetchConfig  `json:"fetch,omitempty"`
+		Warmup     *CacheWarmupConfig `json:"warmup,omitempty"`
+		Stats      *int               `json:"stats,omitempty"`
 	}
 
 	CacheMemoryConfig struct {
-		Cleanup *int `json:"cleanup,omitempty"`
+		Cleanup    *int `json:"cleanup,omitempty"`
+		MaxEntries *int `json:"maxentries,omitempty"`
+		MaxBytes   *int `json:"maxbytes,omitempty"`
+	}
+
+	CacheDiskConfig struct {
+		Path    *string `json:"path,omitempty"`
+		Cleanup *int    `json:"cleanup,omitempty"`
+	}
+
+	CacheRedisConfig struct {
+		Address  *string              `json:"address,omitempty"`
+		DB       *int                 `json:"db,omitempty"`
+		Username *string              `json:"username,omitempty"`
+		Password *string              `json:"password,omitempty"`
+		Prefix   *string              `json:"prefix,omitempty"`
+		Timeout  *int                 `json:"timeout,omitempty"`
+		Retry    *int                 `json:"retry,omitempty"`
+		TLS      *CacheRedisTLSConfig `json:"tls,omitempty"`
+	}
+
+	CacheFetchConfig struct {
+		Expiration *int `json:"expiration,omitempty"`
+		Cleanup    *int `json:"cleanup,omitempty"`
+		MaxEntries *int `json:"maxentries,omitempty"`
+		MaxBytes   *int `json:"maxbytes,omitempty"`
+	}
+
+	CacheWarmupConfig struct {
+		Routes      []string `json:"routes,omitempty"`
+		Concurrency *int     `json:"concurrency,omitempty"`
+		Wait        *bool    `json:"wait,omitempty"`
+	}
+
+	CacheRedisTLSConfig struct {
+		CA                 *string `json:"ca,omitempty"`
+		Cert               *string `json:"cert,omitempty"`
+		Key                *string `json:"key,omitempty"`
+		InsecureSkipVerify *bool   `json:"insecure,omitempty"`
 	}
 
 	//
@@ -43,6 +91,7 @@ type (
 	PrometheusConfig struct {
 		Address       string `json:"address,omitempty"`
 		Endpoint      string `json:"endpoint,omitempty"`
+		Admin         string `json:"admin,omitempty"`
 		SummaryMaxAge *int   `json:"summaryMaxAge,omitempty"`
 		ChanSize      *int   `json:"chanSize,omitempty"`
 
@@ -66,18 +115,84 @@ type (
 		Domains       []string   `json:"domains,omitempty"`
 		TLSConfig     *TLSConfig `json:"tls,omitempty"`
 		ProxyProtocol *string    `json:"proxyprotocol,omitempty"`
+
+		ProxyProtocolPolicy *ListenerProxyProtocolPolicyConfig `json:"proxyprotocolpolicy,omitempty"`
+
+		Timeouts      *ListenerTimeoutsConfig `json:"timeouts,omitempty"`
+		MaxQueryBytes *int                    `json:"maxquerybytes,omitempty"`
+		Limits        *ListenerLimitsConfig   `json:"limits,omitempty"`
+		ACL           *ACLConfig              `json:"acl,omitempty"`
+		Tarpit        *ListenerTarpitConfig   `json:"tarpit,omitempty"`
+	}
+
+	ListenerLimitsConfig struct {
+		MaxConnections      *int    `json:"maxconnections,omitempty"`
+		MaxConnectionsPerIP *int    `json:"maxconnectionsperip,omitempty"`
+		OnLimit             *string `json:"onlimit,omitempty"`
+		QueueTimeout        *int    `json:"queuetimeout,omitempty"`
+	}
+
+	ListenerTarpitConfig struct {
+		Match          []string `json:"match,omitempty"`
+		Patterns       []string `json:"patterns,omitempty"`
+		Banned         *bool    `json:"banned,omitempty"`
+		MaxConnections *int     `json:"maxconnections,omitempty"`
+		Duration       *int     `json:"duration,omitempty"`
+		Interval       *int     `json:"interval,omitempty"`
+
+		PatternsRegexp []*regexp.Regexp `json:"-"`
+	}
+
+	ListenerProxyProtocolPolicyConfig struct {
+		Trusted   []string `json:"trusted,omitempty"`
+		V1        *string  `json:"v1,omitempty"`
+		V2        *string  `json:"v2,omitempty"`
+		Untrusted *string  `json:"untrusted,omitempty"`
+
+		TrustedNets []*net.IPNet `json:"-"`
+	}
+
+	ListenerTimeoutsConfig struct {
+		Handshake *int `json:"handshake,omitempty"`
+		Read      *int `json:"read,omitempty"`
+		Process   *int `json:"process,omitempty"`
+		Write     *int `json:"write,omitempty"`
+		Drain     *int `json:"drain,omitempty"`
 	}
 
 	TLSConfig struct {
-		ACME *TLSACMEConfig `json:"acme,omitempty"`
+		ACME       *TLSACMEConfig       `json:"acme,omitempty"`
+		SelfSigned *TLSSelfSignedConfig `json:"selfsigned,omitempty"`
+		ClientAuth *TLSClientAuthConfig `json:"clientauth,omitempty"`
+	}
+
+	TLSSelfSignedConfig struct {
+		Path     *string `json:"path,omitempty"`
+		Validity *int    `json:"validity,omitempty"`
+		Renew    *bool   `json:"renew,omitempty"`
+	}
+
+	TLSClientAuthConfig struct {
+		Mode             *string `json:"mode,omitempty"`
+		CA               *string `json:"ca,omitempty"`
+		AcceptSelfSigned *bool   `json:"selfsigned,omitempty"`
 	}
 
 	TLSACMEConfig struct {
-		DNSProvider *string               `json:"dnsprovider,omitempty"`
-		Email       *string               `json:"email,omitempty"`
-		CA          *string               `json:"ca,omitempty"`
-		TestCA      *string               `json:"testca,omitempty"`
-		Storage     *TLSACMEStorageConfig `json:"storage,omitempty"`
+		Challenges    []string              `json:"challenges,omitempty"`
+		DNSProvider   *string               `json:"dnsprovider,omitempty"`
+		DNSParameters map[string]string     `json:"dnsparameters,omitempty"`
+		DNSResolvers  []string              `json:"dnsresolvers,omitempty"`
+		HTTP          *TLSACMEHTTPConfig    `json:"http,omitempty"`
+		Email         *string               `json:"email,omitempty"`
+		CA            *string               `json:"ca,omitempty"`
+		TestCA        *string               `json:"testca,omitempty"`
+		TrustedRoots  *string               `json:"trustedroots,omitempty"`
+		Storage       *TLSACMEStorageConfig `json:"storage,omitempty"`
+	}
+
+	TLSACMEHTTPConfig struct {
+		Address *string `json:"address,omitempty"`
 	}
 
 	TLSACMEStorageConfig struct {
@@ -88,6 +203,45 @@ type (
 		Path *string `json:"path,omitempty"`
 	}
 
+	//
+	// ACL
+	//
+	ACLConfig struct {
+		Allow []string `json:"allow,omitempty"`
+		Deny  []string `json:"deny,omitempty"`
+
+		AllowCountries []string `json:"allowcountries,omitempty"`
+		DenyCountries  []string `json:"denycountries,omitempty"`
+		AllowASNs      []uint   `json:"allowasns,omitempty"`
+		DenyASNs       []uint   `json:"denyasns,omitempty"`
+
+		AllowNets []*net.IPNet `json:"-"`
+		DenyNets  []*net.IPNet `json:"-"`
+	}
+
+	//
+	// GeoIP
+	//
+	GeoIPConfig struct {
+		Databases []string `json:"databases,omitempty"`
+	}
+
+	//
+	// Rate limit
+	//
+	RateLimitConfig struct {
+		Rate  *float64            `json:"rate,omitempty"`
+		Burst *int                `json:"burst,omitempty"`
+		Ban   *RateLimitBanConfig `json:"ban,omitempty"`
+	}
+
+	RateLimitBanConfig struct {
+		Threshold *int `json:"threshold,omitempty"`
+		Duration  *int `json:"duration,omitempty"`
+		Factor    *int `json:"factor,omitempty"`
+		Max       *int `json:"max,omitempty"`
+	}
+
 	//
 	// Handler
 	//
@@ -106,10 +260,15 @@ type (
 
 		Listener *ListenerConfig `json:"listener,omitempty"`
 
+		RateLimit *RateLimitConfig `json:"ratelimit,omitempty"`
+
+		GeoIP *GeoIPConfig `json:"geoip,omitempty"`
+
 		BaseDir *string                 `json:"basedir,omitempty"`
 		Routes  map[string]*RouteConfig `json:"routes,omitempty"`
 
 		RoutesRegexp map[string]*RouteRegexpConfig
+		RoutesCache  *LRU
 		Footer       *string
 		Header       *string
 		MutexRoutes  sync.RWMutex
@@ -122,20 +281,39 @@ type (
 		File     *string `json:"file,omitempty"`
 		Template *string `json:"template,omitempty"`
 
-		Fetch map[string]*RouteFetchConfig `json:"fetch,omitempty"`
-		Cache *RouteCacheConfig            `json:"cache,omitempty"`
-		Cron  *string                      `json:"cron,omitempty"`
+		Fetch      map[string]*RouteFetchConfig `json:"fetch,omitempty"`
+		Cache      *RouteCacheConfig            `json:"cache,omitempty"`
+		Cron       *string                      `json:"cron,omitempty"`
+		ClientCert *RouteClientCertConfig       `json:"clientcert,omitempty"`
+		RateLimit  *RouteRateLimitConfig        `json:"ratelimit,omitempty"`
+		ACL        *ACLConfig                   `json:"acl,omitempty"`
 
+		// Name is the name of the route in the configuration (the pattern of a regexp route)
+		Name                 string `json:"-"`
 		RegexpCapturedGroups []string
 	}
 
 	RouteFetchConfig struct {
-		Type *string `json:"type,omitempty"`
-		URI  *string `json:"uri,omitempty"`
+		Type       *string `json:"type,omitempty"`
+		URI        *string `json:"uri,omitempty"`
+		Expiration *int    `json:"expiration,omitempty"`
+	}
+
+	RouteClientCertConfig struct {
+		Required     *bool    `json:"required,omitempty"`
+		Fingerprints []string `json:"fingerprints,omitempty"`
+	}
+
+	RouteRateLimitConfig struct {
+		Rate  *float64 `json:"rate,omitempty"`
+		Burst *int     `json:"burst,omitempty"`
 	}
 
 	RouteCacheConfig struct {
-		Expiration *int `json:"expiration,omitempty"`
+		Expiration           *int     `json:"expiration,omitempty"`
+		Key                  []string `json:"key,omitempty"`
+		StaleWhileRevalidate *int     `json:"stale_while_revalidate,omitempty"`
+		StaleIfError         *int     `json:"stale_if_error,omitempty"`
 	}
 
 	RouteRegexpConfig struct {
@@ -144,11 +322,19 @@ type (
 	}
 )
 
+// GetRoute returns the configuration of the route: the configured routes first, then the routes
+// found from the regexp routes and the files of basedir (kept in RoutesCache).
 func (sc *SpaceConfig) GetRoute(route string) *RouteConfig {
 	sc.MutexRoutes.RLock()
 	routeConfig := sc.Routes[route]
 	sc.MutexRoutes.RUnlock()
 
+	if routeConfig == nil && sc.RoutesCache != nil {
+		if cached, ok := sc.RoutesCache.Get(route); ok {
+			routeConfig = cached.(*RouteConfig)
+		}
+	}
+
 	if routeConfig == nil {
 		// try to find it in the Regexp routes
 		for _, routeRegexpConf := range sc.RoutesRegexp {
@@ -166,6 +352,10 @@ func (sc *SpaceConfig) GetRoute(route string) *RouteConfig {
 				Fetch:                routeRegexpConf.Fetch,
 				Cache:                routeRegexpConf.Cache,
 				Cron:                 routeRegexpConf.Cron,
+				ClientCert:           routeRegexpConf.ClientCert,
+				RateLimit:            routeRegexpConf.RateLimit,
+				ACL:                  routeRegexpConf.ACL,
+				Name:                 routeRegexpConf.Name,
 				RegexpCapturedGroups: capturedGroups,
 			}
 
@@ -180,8 +370,9 @@ func (sc *SpaceConfig) GetRoute(route string) *RouteConfig {
 						File:                 nil,
 						Template:             String(tpl),
 						Fetch:                nil,
-						Cache:                &RouteCacheConfig{Expiration: sc.Cache.Expiration},
+						Cache:                &RouteCacheConfig{Expiration: sc.Cache.Expiration, Key: sc.Cache.Key},
 						Cron:                 nil,
+						Name:                 route,
 						RegexpCapturedGroups: nil,
 					}
 				}
@@ -191,20 +382,126 @@ func (sc *SpaceConfig) GetRoute(route string) *RouteConfig {
 						File:                 String(file),
 						Template:             nil,
 						Fetch:                nil,
-						Cache:                &RouteCacheConfig{Expiration: sc.Cache.Expiration},
+						Cache:                &RouteCacheConfig{Expiration: sc.Cache.Expiration, Key: sc.Cache.Key},
 						Cron:                 nil,
+						Name:                 route,
 						RegexpCapturedGroups: nil,
 					}
 				}
 			}
 		}
 
-		if routeConfig != nil {
-			sc.MutexRoutes.Lock()
-			sc.Routes[route] = routeConfig
-			sc.MutexRoutes.Unlock()
+		if routeConfig != nil && sc.RoutesCache != nil {
+			sc.RoutesCache.Set(route, routeConfig, 0, 0)
 		}
 	}
 
 	return routeConfig
 }
+
+// Compile parses the allow and deny lists.
+func (a *ACLConfig) Compile() error {
+	var err error
+
+	for i, country := range a.AllowCountries {
+		a.AllowCountries[i] = strings.ToUpper(country)
+	}
+
+	for i, country := range a.DenyCountries {
+		a.DenyCountries[i] = strings.ToUpper(country)
+	}
+
+	if a.AllowNets, err = ParseCIDRs(a.Allow); err != nil {
+		return err
+	}
+
+	if a.DenyNets, err = ParseCIDRs(a.Deny); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsAllowed tells if an IP is allowed: a denied IP is never allowed and,
+// if there is an allow list, the IP has to be in it.
+// The countries and the ASNs are the ones found by the GeoIP lookup of the IP (nil if unknown).
+func (a *ACLConfig) IsAllowed(ip string, geoip *GeoIPInfo) bool {
+	if a == nil {
+		return true
+	}
+
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return len(a.AllowNets) == 0 && len(a.DenyNets) == 0 && !a.UsesGeoIP()
+	}
+
+	var country string
+	var asn uint
+
+	if geoip != nil {
+		country = geoip.Country
+		asn = geoip.ASN
+	}
+
+	for _, n := range a.DenyNets {
+		if n.Contains(parsedIP) {
+			return false
+		}
+	}
+
+	if (country != "" && StringSliceContains(a.DenyCountries, country)) ||
+		(asn != 0 && uintSliceContains(a.DenyASNs, asn)) {
+		return false
+	}
+
+	if len(a.AllowNets) == 0 && len(a.AllowCountries) == 0 && len(a.AllowASNs) == 0 {
+		return true
+	}
+
+	for _, n := range a.AllowNets {
+		if n.Contains(parsedIP) {
+			return true
+		}
+	}
+
+	return (country != "" && StringSliceContains(a.AllowCountries, country)) ||
+		(asn != 0 && uintSliceContains(a.AllowASNs, asn))
+}
+
+// UsesGeoIP tells if the ACL has countries or ASNs.
+func (a *ACLConfig) UsesGeoIP() bool {
+	return a != nil &&
+		(len(a.AllowCountries) != 0 || len(a.DenyCountries) != 0 || len(a.AllowASNs) != 0 || len(a.DenyASNs) != 0)
+}
+
+func uintSliceContains(s []uint, e uint) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RouteMatcher returns the function matching the routes of a pattern: a regexp if it starts with a ~
+// (as the regexp routes), a prefix if it ends with a *, else the name of a route.
+func RouteMatcher(pattern string) (func(route string) bool, error) {
+	switch {
+	case pattern == "":
+		return nil, fmt.Errorf("empty route pattern")
+	case pattern[0] == '~':
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid route regexp: %s", err)
+		}
+
+		return re.MatchString, nil
+	case strings.HasSuffix(pattern, "*"):
+		prefix := strings.TrimSuffix(pattern, "*")
+
+		return func(route string) bool { return strings.HasPrefix(route, prefix) }, nil
+	default:
+		return func(route string) bool { return route == pattern }, nil
+	}
+}
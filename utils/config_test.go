@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestACLConfigIsAllowed(t *testing.T) {
+	fr := &GeoIPInfo{Country: "FR", ASN: 12322}
+	us := &GeoIPInfo{Country: "US", ASN: 15169}
+
+	tests := []struct {
+		name   string
+		acl    *ACLConfig
+		ip     string
+		geoip  *GeoIPInfo
+		wanted bool
+	}{
+		{"no acl", nil, "192.0.2.1", nil, true},
+		{"empty acl", &ACLConfig{}, "192.0.2.1", nil, true},
+		{"empty acl, invalid ip", &ACLConfig{}, "invalid", nil, true},
+
+		{"allow list, in", &ACLConfig{Allow: []string{"192.0.2.0/24"}}, "192.0.2.1", nil, true},
+		{"allow list, out", &ACLConfig{Allow: []string{"192.0.2.0/24"}}, "198.51.100.1", nil, false},
+		{"allow list, single ip", &ACLConfig{Allow: []string{"192.0.2.1"}}, "192.0.2.1", nil, true},
+		{"allow list, ipv6", &ACLConfig{Allow: []string{"2001:db8::/32"}}, "2001:db8::1", nil, true},
+		{"allow list, invalid ip", &ACLConfig{Allow: []string{"192.0.2.0/24"}}, "invalid", nil, false},
+
+		{"deny list, in", &ACLConfig{Deny: []string{"192.0.2.0/24"}}, "192.0.2.1", nil, false},
+		{"deny list, out", &ACLConfig{Deny: []string{"192.0.2.0/24"}}, "198.51.100.1", nil, true},
+		{"deny has precedence", &ACLConfig{Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.1"}}, "192.0.2.1", nil, false},
+		{"deny has precedence, other ip", &ACLConfig{Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.1"}}, "192.0.2.2", nil, true},
+
+		{"allow country, in", &ACLConfig{AllowCountries: []string{"fr"}}, "192.0.2.1", fr, true},
+		{"allow country, out", &ACLConfig{AllowCountries: []string{"fr"}}, "192.0.2.1", us, false},
+		{"allow country, unknown", &ACLConfig{AllowCountries: []string{"fr"}}, "192.0.2.1", nil, false},
+		{"deny country, in", &ACLConfig{DenyCountries: []string{"US"}}, "192.0.2.1", us, false},
+		{"deny country, unknown", &ACLConfig{DenyCountries: []string{"US"}}, "192.0.2.1", nil, true},
+		{"allow asn, in", &ACLConfig{AllowASNs: []uint{12322}}, "192.0.2.1", fr, true},
+		{"deny asn, in", &ACLConfig{DenyASNs: []uint{15169}}, "192.0.2.1", us, false},
+		{"allow net or country", &ACLConfig{Allow: []string{"192.0.2.0/24"}, AllowCountries: []string{"FR"}}, "198.51.100.1", fr, true},
+		{"deny country over allow net", &ACLConfig{Allow: []string{"192.0.2.0/24"}, DenyCountries: []string{"US"}}, "192.0.2.1", us, false},
+		{"geoip rules, invalid ip", &ACLConfig{DenyCountries: []string{"US"}}, "invalid", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.acl != nil {
+				if err := tt.acl.Compile(); err != nil {
+					t.Fatalf("unable to compile the acl: %s", err)
+				}
+			}
+
+			if got := tt.acl.IsAllowed(tt.ip, tt.geoip); got != tt.wanted {
+				t.Errorf("IsAllowed(%q) = %t, wanted %t", tt.ip, got, tt.wanted)
+			}
+		})
+	}
+}
+
+func TestACLConfigCompile(t *testing.T) {
+	if err := (&ACLConfig{Allow: []string{"not a cidr"}}).Compile(); err == nil {
+		t.Errorf("an invalid allow list is accepted")
+	}
+
+	if err := (&ACLConfig{Deny: []string{"192.0.2.0/33"}}).Compile(); err == nil {
+		t.Errorf("an invalid deny list is accepted")
+	}
+}
+
+func TestRouteMatcher(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matched []string
+		ignored []string
+	}{
+		{"blog", []string{"blog"}, []string{"blog/2020", "bloggers", "index"}},
+		{"blog/*", []string{"blog/", "blog/2020", "blog/2020/01"}, []string{"blog", "about/blog/x"}},
+		{"*", []string{"", "index", "blog/2020"}, nil},
+		{`~^blog/(\d{4})$`, []string{"blog/2020"}, []string{"blog/20", "blog/2020/01", "index"}},
+		{"~stats", []string{"about/stats", "stats"}, []string{"about/server"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			match, err := RouteMatcher(tt.pattern)
+			if err != nil {
+				t.Fatalf("unable to compile the pattern: %s", err)
+			}
+
+			for _, route := range tt.matched {
+				if !match(route) {
+					t.Errorf("%q isn't matched", route)
+				}
+			}
+
+			for _, route := range tt.ignored {
+				if match(route) {
+					t.Errorf("%q is matched", route)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteMatcherInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "~(", "~[a-"} {
+		if _, err := RouteMatcher(pattern); err == nil {
+			t.Errorf("the pattern %q is accepted", pattern)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"time"
 
@@ -11,95 +14,117 @@ import (
 	"github.com/prometheus/prom2json"
 )
 
-func GetURL(timeout time.Duration, url string) ([]byte, error) {
-	ctxFeed, ctxFeedCancel := context.WithTimeout(context.Background(), timeout)
-	defer ctxFeedCancel()
+// FetchResult is the parsed response of a fetch with its validators.
+type FetchResult struct {
+	Data         interface{}
+	Size         int
+	ETag         string
+	LastModified string
+	NotModified  bool
+}
+
+// Fetch gets the URL and parses the body according to the type of the fetch (html, feed, json or prometheus).
+// With the validators of a previous response, the request is conditional: if the resource hasn't changed,
+// the result is NotModified and has no data.
+func Fetch(timeout time.Duration, fetchType string, url string, etag string, lastModified string) (*FetchResult, error) {
+	ctxFetch, ctxFetchCancel := context.WithTimeout(context.Background(), timeout)
+	defer ctxFetchCancel()
 
-	req, err := http.NewRequestWithContext(ctxFeed, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctxFetch, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if fetchType == "prometheus" {
+		req.Header.Set("Accept", "text/plain;version=0.0.4")
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	var result []byte
-	if _, err := resp.Body.Read(result); err != nil {
-		return nil, err
+	result := &FetchResult{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
 	}
 
-	return result, nil
-}
+	if resp.StatusCode == http.StatusNotModified && (etag != "" || lastModified != "") {
+		result.NotModified = true
 
-func GetJSON(timeout time.Duration, url string) (map[string]interface{}, error) {
-	myClient := &http.Client{Timeout: timeout}
-	data := make(map[string]interface{})
+		return result, nil
+	}
 
-	r, err := myClient.Get(url)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("GET %s returned HTTP status %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	defer r.Body.Close()
 
-	err = json.NewDecoder(r.Body).Decode(&data)
+	result.Size = len(body)
+
+	switch fetchType {
+	case "html":
+		result.Data = body
+	case "feed":
+		result.Data, err = ParseFeed(body)
+	case "json":
+		result.Data, err = ParseJSON(body)
+	case "prometheus":
+		result.Data, err = ParsePrometheus(body)
+	default:
+		err = fmt.Errorf("unknown fetch type %s", fetchType)
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return result, nil
 }
 
-func GetFeed(timeout time.Duration, url string) (*gofeed.Feed, error) {
-	ctxFeed, ctxFeedCancel := context.WithTimeout(context.Background(), timeout)
-	defer ctxFeedCancel()
-
-	fp := gofeed.NewParser()
-	feed, err := fp.ParseURLWithContext(url, ctxFeed)
+func ParseJSON(body []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
 
-	if err != nil {
+	if err := json.Unmarshal(body, &data); err != nil {
 		return nil, err
 	}
 
-	return feed, nil
+	return data, nil
 }
 
-func GetPrometheus(timeout time.Duration, url string) (map[string]*prom2json.Family, error) {
-	mfChan := make(chan *dto.MetricFamily, 1024)
-	errChan := make(chan error)
-	defer close(errChan)
+func ParseFeed(body []byte) (*gofeed.Feed, error) {
+	return gofeed.NewParser().Parse(bytes.NewReader(body))
+}
 
-	// Initialize with the DefaultTransport for sane defaults.
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	// Conservatively disable HTTP keep-alives as this program will only
-	// ever need a single HTTP request.
-	transport.DisableKeepAlives = true
-	// Timeout early if the server doesn't even return the headers.
-	transport.ResponseHeaderTimeout = timeout
+func ParsePrometheus(body []byte) (map[string]*prom2json.Family, error) {
+	mfChan := make(chan *dto.MetricFamily, 1024)
+	errChan := make(chan error, 1)
 
 	go func() {
-		if err := prom2json.FetchMetricFamilies(url, mfChan, transport); err != nil {
-			errChan <- err
-			return
-		}
-
-		errChan <- nil
+		errChan <- prom2json.ParseReader(bytes.NewReader(body), mfChan)
 	}()
 
-	for err := range errChan {
-		if err != nil {
-			return nil, err
-		}
-
-		break
-	}
-
 	result := map[string]*prom2json.Family{}
 	for mf := range mfChan {
 		result[*mf.Name] = prom2json.NewFamily(mf)
 	}
 
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
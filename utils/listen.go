@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+type (
+	// trackedListener is a listener that can be handed over to a new process.
+	trackedListener struct {
+		net.Listener
+
+		address string
+	}
+)
+
+const (
+	// InheritedListenersEnv lists the addresses of the listeners inherited from
+	// the previous process, in the order of their file descriptors (starting at 3).
+	InheritedListenersEnv = "TTSERVER_LISTENERS"
+
+	// firstInheritedFd is the first file descriptor after stdin, stdout and stderr.
+	firstInheritedFd = 3
+)
+
+var (
+	listeners          = make(map[string]*trackedListener)
+	inheritedListeners map[string]net.Listener
+	muListeners        sync.Mutex
+)
+
+// Listen listens on the TCP address, the listener inherited from the previous process
+// is reused if there is one for this address.
+func Listen(address string) (net.Listener, error) {
+	muListeners.Lock()
+	defer muListeners.Unlock()
+
+	if err := loadInheritedListeners(); err != nil {
+		return nil, err
+	}
+
+	ln, ok := inheritedListeners[address]
+	if ok {
+		delete(inheritedListeners, address)
+	} else {
+		var err error
+
+		if ln, err = net.Listen("tcp", address); err != nil {
+			return nil, err
+		}
+	}
+
+	tracked := &trackedListener{Listener: ln, address: address}
+	listeners[address] = tracked
+
+	return tracked, nil
+}
+
+// CloseInheritedListeners closes the inherited listeners not used by the configuration.
+func CloseInheritedListeners() {
+	muListeners.Lock()
+	defer muListeners.Unlock()
+
+	for address, ln := range inheritedListeners {
+		ln.Close()
+		delete(inheritedListeners, address)
+	}
+}
+
+// ListenerFiles returns the addresses and the files of the listeners,
+// the files have to be closed by the caller.
+func ListenerFiles() ([]string, []*os.File, error) {
+	muListeners.Lock()
+	defer muListeners.Unlock()
+
+	var addresses []string
+	var files []*os.File
+
+	for address, ln := range listeners {
+		tcpListener, ok := ln.Listener.(*net.TCPListener)
+		if !ok {
+			continue
+		}
+
+		f, err := tcpListener.File()
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+
+			return nil, nil, fmt.Errorf("unable to get the file of the listener %s: %s", address, err)
+		}
+
+		addresses = append(addresses, address)
+		files = append(files, f)
+	}
+
+	return addresses, files, nil
+}
+
+// RestoreListeners sets the listeners back to the non-blocking mode,
+// lost when their files are handed over to a new process.
+func RestoreListeners() error {
+	muListeners.Lock()
+	defer muListeners.Unlock()
+
+	for address, ln := range listeners {
+		sc, ok := ln.Listener.(syscall.Conn)
+		if !ok {
+			continue
+		}
+
+		rawConn, err := sc.SyscallConn()
+		if err != nil {
+			return fmt.Errorf("unable to restore the listener %s: %s", address, err)
+		}
+
+		var errNonblock error
+		if err := rawConn.Control(func(fd uintptr) {
+			errNonblock = syscall.SetNonblock(int(fd), true)
+		}); err != nil {
+			return fmt.Errorf("unable to restore the listener %s: %s", address, err)
+		}
+
+		if errNonblock != nil {
+			return fmt.Errorf("unable to restore the listener %s: %s", address, errNonblock)
+		}
+	}
+
+	return nil
+}
+
+// loadInheritedListeners must be called with the lock held.
+func loadInheritedListeners() error {
+	if inheritedListeners != nil {
+		return nil
+	}
+
+	inheritedListeners = make(map[string]net.Listener)
+
+	env := os.Getenv(InheritedListenersEnv)
+	if env == "" {
+		return nil
+	}
+
+	for i, address := range strings.Split(env, ",") {
+		f := os.NewFile(uintptr(firstInheritedFd+i), address)
+
+		ln, err := net.FileListener(f)
+		f.Close()
+
+		if err != nil {
+			return fmt.Errorf("unable to inherit the listener %s: %s", address, err)
+		}
+
+		inheritedListeners[address] = ln
+	}
+
+	return nil
+}
+
+func (l *trackedListener) Close() error {
+	muListeners.Lock()
+	if listeners[l.address] == l {
+		delete(listeners, l.address)
+	}
+	muListeners.Unlock()
+
+	return l.Listener.Close()
+}
@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	// LRU is a map bounded by a number of entries and a size in bytes (0 means no limit),
+	// the least recently used entries are evicted first.
+	LRU struct {
+		maxEntries int
+		maxBytes   int
+
+		items map[string]*list.Element
+		order *list.List
+		bytes int
+
+		expired int
+
+		onEvict func(evicted int)
+
+		mu sync.Mutex
+	}
+
+	lruEntry struct {
+		key        string
+		value      interface{}
+		size       int
+		expiration int64
+	}
+)
+
+func NewLRU(maxEntries int, maxBytes int) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// SetOnEvict sets the function called with the number of entries evicted to make room,
+// the expired entries are not counted.
+func (l *LRU) SetOnEvict(onEvict func(evicted int)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onEvict = onEvict
+}
+
+// Get returns the value if it exists and hasn't expired, it becomes the most recently used.
+func (l *LRU) Get(key string) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	l.order.MoveToFront(element)
+
+	return element.Value.(*lruEntry).value, true
+}
+
+// Set stores the value, replacing the existing one. The expiration is a Unix time in nanoseconds,
+// 0 means no expiration. A value larger than the size limit isn't stored.
+func (l *LRU) Set(key string, value interface{}, size int, expiration int64) bool {
+	return l.store(key, value, size, expiration, func(exists bool) bool { return true })
+}
+
+// Add stores the value only if the key doesn't exist yet.
+func (l *LRU) Add(key string, value interface{}, size int, expiration int64) bool {
+	return l.store(key, value, size, expiration, func(exists bool) bool { return !exists })
+}
+
+// Replace stores the value only if the key already exists.
+func (l *LRU) Replace(key string, value interface{}, size int, expiration int64) bool {
+	return l.store(key, value, size, expiration, func(exists bool) bool { return exists })
+}
+
+func (l *LRU) store(key string, value interface{}, size int, expiration int64, condition func(exists bool) bool) bool {
+	l.mu.Lock()
+
+	element, exists := l.get(key)
+	if !condition(exists) {
+		l.mu.Unlock()
+		return false
+	}
+
+	if l.maxBytes > 0 && size > l.maxBytes {
+		if exists {
+			l.remove(element)
+		}
+
+		l.mu.Unlock()
+		return false
+	}
+
+	if exists {
+		entry := element.Value.(*lruEntry)
+		l.bytes += size - entry.size
+		entry.value = value
+		entry.size = size
+		entry.expiration = expiration
+
+		l.order.MoveToFront(element)
+	} else {
+		l.items[key] = l.order.PushFront(&lruEntry{
+			key:        key,
+			value:      value,
+			size:       size,
+			expiration: expiration,
+		})
+		l.bytes += size
+	}
+
+	evicted := 0
+
+	for (l.maxEntries > 0 && l.order.Len() > l.maxEntries) || (l.maxBytes > 0 && l.bytes > l.maxBytes) {
+		l.remove(l.order.Back())
+		evicted++
+	}
+
+	onEvict := l.onEvict
+	l.mu.Unlock()
+
+	if evicted > 0 && onEvict != nil {
+		onEvict(evicted)
+	}
+
+	return true
+}
+
+func (l *LRU) Delete(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.items[key]; ok {
+		l.remove(element)
+	}
+}
+
+// DeleteFunc removes the entries whose key matches and returns their number.
+func (l *LRU) DeleteFunc(match func(key string) bool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	deleted := 0
+
+	for element := l.order.Front(); element != nil; {
+		next := element.Next()
+
+		if match(element.Value.(*lruEntry).key) {
+			l.remove(element)
+			deleted++
+		}
+
+		element = next
+	}
+
+	return deleted
+}
+
+// DeleteExpired removes the expired entries and returns their number.
+func (l *LRU) DeleteExpired() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	deleted := 0
+
+	for element := l.order.Back(); element != nil; {
+		prev := element.Prev()
+
+		if entry := element.Value.(*lruEntry); entry.expiration > 0 && now > entry.expiration {
+			l.remove(element)
+			deleted++
+		}
+
+		element = prev
+	}
+
+	l.expired += deleted
+
+	return deleted
+}
+
+func (l *LRU) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.items = make(map[string]*list.Element)
+	l.order.Init()
+	l.bytes = 0
+}
+
+// Range calls f for each entry that hasn't expired, the most recently used first, until f returns false.
+// The order of the entries isn't changed.
+func (l *LRU) Range(f func(key string, value interface{}, size int, expiration int64) bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UnixNano()
+
+	for element := l.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*lruEntry)
+
+		if entry.expiration > 0 && now > entry.expiration {
+			continue
+		}
+
+		if !f(entry.key, entry.value, entry.size, entry.expiration) {
+			return
+		}
+	}
+}
+
+// Expired returns the number of entries removed once expired.
+func (l *LRU) Expired() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.expired
+}
+
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.order.Len()
+}
+
+func (l *LRU) Bytes() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bytes
+}
+
+// get returns the element if it hasn't expired, the lock must be held.
+func (l *LRU) get(key string) (*list.Element, bool) {
+	element, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	if entry := element.Value.(*lruEntry); entry.expiration > 0 && time.Now().UnixNano() > entry.expiration {
+		l.remove(element)
+		l.expired++
+
+		return nil, false
+	}
+
+	return element, true
+}
+
+// remove removes the element, the lock must be held.
+func (l *LRU) remove(element *list.Element) {
+	entry := l.order.Remove(element).(*lruEntry)
+	delete(l.items, entry.key)
+	l.bytes -= entry.size
+}
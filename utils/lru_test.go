@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// lruKeys returns the keys, the most recently used first.
+func lruKeys(l *LRU) []string {
+	var keys []string
+
+	l.Range(func(key string, value interface{}, size int, expiration int64) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	return keys
+}
+
+func TestLRUMaxEntries(t *testing.T) {
+	l := NewLRU(2, 0)
+
+	var evicted int
+	l.SetOnEvict(func(n int) { evicted += n })
+
+	l.Set("a", 1, 1, 0)
+	l.Set("b", 2, 1, 0)
+
+	// a becomes the most recently used, b is evicted
+	if _, ok := l.Get("a"); !ok {
+		t.Fatalf("a not found")
+	}
+
+	l.Set("c", 3, 1, 0)
+
+	if _, ok := l.Get("b"); ok {
+		t.Errorf("b has not been evicted")
+	}
+
+	if diff := cmp.Diff([]string{"c", "a"}, lruKeys(l)); diff != "" {
+		t.Errorf("unexpected keys (-wanted +got):\n%s", diff)
+	}
+
+	if evicted != 1 {
+		t.Errorf("%d evictions reported, wanted 1", evicted)
+	}
+}
+
+func TestLRUMaxBytes(t *testing.T) {
+	l := NewLRU(0, 10)
+
+	l.Set("a", "a", 4, 0)
+	l.Set("b", "b", 4, 0)
+	l.Set("c", "c", 4, 0)
+
+	if diff := cmp.Diff([]string{"c", "b"}, lruKeys(l)); diff != "" {
+		t.Errorf("unexpected keys (-wanted +got):\n%s", diff)
+	}
+
+	if l.Bytes() != 8 {
+		t.Errorf("%d bytes, wanted 8", l.Bytes())
+	}
+
+	// larger than the limit: not stored, and the previous value is removed
+	if l.Set("b", "B", 11, 0) {
+		t.Errorf("a value larger than the limit is stored")
+	}
+
+	if _, ok := l.Get("b"); ok {
+		t.Errorf("the previous value is kept")
+	}
+
+	if l.Bytes() != 4 || l.Len() != 1 {
+		t.Errorf("%d entries and %d bytes, wanted 1 and 4", l.Len(), l.Bytes())
+	}
+
+	// the size of a replaced value is updated
+	l.Set("c", "C", 6, 0)
+
+	if l.Bytes() != 6 {
+		t.Errorf("%d bytes, wanted 6", l.Bytes())
+	}
+}
+
+func TestLRUConditions(t *testing.T) {
+	l := NewLRU(0, 0)
+
+	if l.Replace("a", 1, 1, 0) {
+		t.Errorf("a missing key is replaced")
+	}
+
+	if !l.Add("a", 1, 1, 0) {
+		t.Errorf("a missing key isn't added")
+	}
+
+	if l.Add("a", 2, 1, 0) {
+		t.Errorf("an existing key is added")
+	}
+
+	if !l.Replace("a", 3, 1, 0) {
+		t.Errorf("an existing key isn't replaced")
+	}
+
+	if value, _ := l.Get("a"); value != 3 {
+		t.Errorf("a = %v, wanted 3", value)
+	}
+}
+
+func TestLRUExpiration(t *testing.T) {
+	l := NewLRU(0, 0)
+
+	past := time.Now().Add(-time.Second).UnixNano()
+	future := time.Now().Add(time.Hour).UnixNano()
+
+	l.Set("expired", 1, 1, past)
+	l.Set("fresh", 2, 1, future)
+	l.Set("forever", 3, 1, 0)
+
+	if diff := cmp.Diff([]string{"forever", "fresh"}, lruKeys(l)); diff != "" {
+		t.Errorf("unexpected keys (-wanted +got):\n%s", diff)
+	}
+
+	// the expired entries are still counted until they are removed
+	if l.Len() != 3 {
+		t.Errorf("%d entries, wanted 3", l.Len())
+	}
+
+	if deleted := l.DeleteExpired(); deleted != 1 {
+		t.Errorf("%d expired entries deleted, wanted 1", deleted)
+	}
+
+	l.Set("expired", 1, 1, past)
+
+	if _, ok := l.Get("expired"); ok {
+		t.Errorf("an expired entry is returned")
+	}
+
+	if l.Expired() != 2 || l.Len() != 2 || l.Bytes() != 2 {
+		t.Errorf("%d expired, %d entries and %d bytes, wanted 2, 2 and 2", l.Expired(), l.Len(), l.Bytes())
+	}
+}
+
+func TestLRUDelete(t *testing.T) {
+	l := NewLRU(0, 0)
+
+	for _, key := range []string{"blog/1", "blog/2", "index"} {
+		l.Set(key, key, 1, 0)
+	}
+
+	l.Delete("index")
+	l.Delete("missing")
+
+	if deleted := l.DeleteFunc(func(key string) bool { return key == "blog/1" }); deleted != 1 {
+		t.Errorf("%d entries deleted, wanted 1", deleted)
+	}
+
+	if diff := cmp.Diff([]string{"blog/2"}, lruKeys(l)); diff != "" {
+		t.Errorf("unexpected keys (-wanted +got):\n%s", diff)
+	}
+
+	l.Flush()
+
+	if l.Len() != 0 || l.Bytes() != 0 {
+		t.Errorf("%d entries and %d bytes after a flush", l.Len(), l.Bytes())
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+)
+
+type (
+	// GeoIPInfo is what the GeoIP databases know about an IP.
+	GeoIPInfo struct {
+		Country        string
+		ASN            uint
+		ASOrganization string
+	}
+)
+
+// ParseCIDRs parses a list of CIDRs, a single IP is considered as a /32 (or /128) network.
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s", entry)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
@@ -38,3 +38,17 @@ func SplitRune(s string, separators ...rune) []string {
 
 	return strings.FieldsFunc(s, f)
 }
+
+func NormalizeFingerprint(s string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(s), ":", "", -1))
+}
+
+func StringSliceContains(v []string, s string) bool {
+	for _, item := range v {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}